@@ -0,0 +1,50 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+// Combined manager for mfakto and clLucas
+
+package main
+
+import (
+	"github.com/Kunde21/MersenneManager/config"
+	"github.com/Kunde21/MersenneManager/manager"
+)
+
+var app = manager.App{
+	SettingsFile: "MMsettings.yml",
+	LogPrefix:    "MMgr: ",
+	KindOf:       manager.DeviceKind,
+	GPU72:        true,
+	Defaults:     defaults,
+}
+
+// defaults returns the default settings.
+func defaults() config.Settings {
+	return config.Settings{
+		Polltime: 2,
+		Ledger:   "MMledger.db",
+		Cookies:  "MMcookies.json",
+		CredFile: "MMcredentials.yml",
+		Devices: []config.Device{{
+			Kind:       "mfakto",
+			Device:     0,
+			Workdir:    "mfakto",
+			WorkType:   "lltf",
+			WorkOption: "what_makes_sense",
+			Target:     73,
+			Cache:      5,
+		}, {
+			Kind:     "clLucas",
+			Device:   1,
+			Workdir:  "clLucas",
+			WorkType: "101",
+			Cache:    2,
+			GpuTh:    128,
+		}},
+	}
+}
+
+func main() {
+	app.Main()
+}
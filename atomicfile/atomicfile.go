@@ -0,0 +1,124 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+// Package atomicfile replaces files so that a reader, or a crash part way
+// through, sees either the old contents or the new ones and never a mix.
+package atomicfile
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// The file operations that can fail part way through, replaced in tests.
+var (
+	tempFile = ioutil.TempFile
+	rename   = os.Rename
+)
+
+// Backups is the number of earlier versions kept, as path.bak (newest),
+// path.bak.1, path.bak.2 and so on.
+var Backups = 3
+
+// WriteFile replaces the contents of path with data.  data is written to a
+// temporary file in the same directory and synced to disk before it is
+// renamed over path, and the version it replaces is kept as path.bak.
+// Nothing is written when path already holds data.
+//
+// If WriteFile fails, path is left as it was.
+func WriteFile(path string, data []byte) error {
+	old, err := ioutil.ReadFile(path)
+	exists := err == nil
+	switch {
+	case exists && bytes.Equal(old, data):
+		return nil
+	case err != nil && !os.IsNotExist(err):
+		return err
+	}
+	perm := os.FileMode(0664)
+	if fi, err := os.Stat(path); err == nil {
+		perm = fi.Mode().Perm()
+	}
+
+	// Temp files left by a crash
+	stale, _ := filepath.Glob(path + ".tmp*")
+	for _, f := range stale {
+		os.Remove(f)
+	}
+	tmp, err := write(path, data, perm)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if err := backup(path, old, perm); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// write stores data in a new temporary file next to path and returns its
+// name.  The file is removed if any step fails.
+func write(path string, data []byte, perm os.FileMode) (string, error) {
+	f, err := tempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), perm)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("writing %s: %w", path, err)
+	}
+	return f.Name(), nil
+}
+
+// backup shifts the older backups of path along and saves old as path.bak.
+func backup(path string, old []byte, perm os.FileMode) error {
+	if Backups <= 0 {
+		return nil
+	}
+	for i := Backups - 1; i > 0; i-- {
+		err := os.Rename(bakName(path, i-1), bakName(path, i))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return ioutil.WriteFile(bakName(path, 0), old, perm)
+}
+
+func bakName(path string, i int) string {
+	if i == 0 {
+		return path + ".bak"
+	}
+	return fmt.Sprintf("%s.bak.%d", path, i)
+}
+
+// syncDir flushes the rename to disk.  Not every platform can sync a
+// directory, so errors are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
@@ -0,0 +1,119 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package atomicfile
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// versions writes "v0" to "v<n-1>" to path in turn.
+func versions(t *testing.T, path string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := WriteFile(path, []byte(fmt.Sprint("v", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkFiles compares the files in path's directory with want, by name
+// relative to path.
+func checkFiles(t *testing.T, path string, want map[string]string) {
+	t.Helper()
+	names, _ := filepath.Glob(path + "*")
+	got := make(map[string]string)
+	for _, name := range names {
+		data, _ := ioutil.ReadFile(name)
+		got[name[len(path):]] = string(data)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "worktodo.txt")
+	versions(t, path, 6)
+	checkFiles(t, path, map[string]string{"": "v5", ".bak": "v4", ".bak.1": "v3", ".bak.2": "v2"})
+
+	// Unchanged data leaves the backups alone
+	if err := WriteFile(path, []byte("v5")); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, path, map[string]string{"": "v5", ".bak": "v4", ".bak.1": "v3", ".bak.2": "v2"})
+
+	if runtime.GOOS != "windows" {
+		os.Chmod(path, 0600)
+		if err := WriteFile(path, []byte("v6")); err != nil {
+			t.Fatal(err)
+		}
+		if fi, _ := os.Stat(path); fi.Mode().Perm() != 0600 {
+			t.Errorf("mode = %v, want 0600 kept", fi.Mode().Perm())
+		}
+	}
+}
+
+func TestNoBackups(t *testing.T) {
+	defer func(n int) { Backups = n }(Backups)
+	Backups = 0
+	path := filepath.Join(t.TempDir(), "results.txt")
+	versions(t, path, 3)
+	checkFiles(t, path, map[string]string{"": "v2"})
+}
+
+func TestStaleTemp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "worktodo.txt")
+	ioutil.WriteFile(path+".tmp123456", []byte("left by a crash"), 0664)
+	versions(t, path, 1)
+	checkFiles(t, path, map[string]string{"": "v0"})
+}
+
+// readOnlyTemp creates a temporary file that can't be written to, as if
+// the disk were full.
+func readOnlyTemp(dir, pattern string) (*os.File, error) {
+	f, err := ioutil.TempFile(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return os.Open(f.Name())
+}
+
+func TestWriteFailure(t *testing.T) {
+	defer func(f func(string, string) (*os.File, error)) { tempFile = f }(tempFile)
+	tempFile = readOnlyTemp
+	path := filepath.Join(t.TempDir(), "worktodo.txt")
+	if err := WriteFile(path, []byte("v0")); err == nil {
+		t.Error("no error for a new file")
+	}
+	checkFiles(t, path, map[string]string{})
+
+	tempFile = ioutil.TempFile
+	versions(t, path, 3)
+	tempFile = readOnlyTemp
+	if err := WriteFile(path, []byte("v3")); err == nil {
+		t.Error("no error replacing a file")
+	}
+	checkFiles(t, path, map[string]string{"": "v2", ".bak": "v1", ".bak.1": "v0"})
+}
+
+func TestRenameFailure(t *testing.T) {
+	defer func(f func(string, string) error) { rename = f }(rename)
+	path := filepath.Join(t.TempDir(), "results.txt")
+	versions(t, path, 2)
+	failed := errors.New("rename failed")
+	rename = func(string, string) error { return failed }
+	if err := WriteFile(path, []byte("v2")); !errors.Is(err, failed) {
+		t.Errorf("err = %v, want the rename error", err)
+	}
+	// The backups were rotated before the rename, and keep every version
+	checkFiles(t, path, map[string]string{"": "v1", ".bak": "v1", ".bak.1": "v0"})
+}
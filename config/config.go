@@ -0,0 +1,151 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+// Package config reads and writes the yaml settings files shared by the managers.
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// MaxPoll is the longest polling delay, in hours, the managers will honor.
+const MaxPoll = 120
+
+// Settings holds the account and device configuration for a manager.
+type Settings struct {
+	Usrname   string   `yaml:"UserName"`
+	Pass      string   `yaml:"Password,omitempty"`
+	GPU72Usr  string   `yaml:"GPU72UserName,omitempty"`
+	GPU72Pass string   `yaml:"GPU72Password,omitempty"`
+	Polltime  uint     `yaml:"Poll"`
+	LogFile   string   `yaml:"Logs"`
+	V5        bool     `yaml:"PrimenetV5,omitempty"`
+	Unreserve bool     `yaml:"UnreserveRemoved,omitempty"` // release work of devices dropped from Devices
+	Ledger    string   `yaml:"Ledger,omitempty"`           // assignment and result history database
+	Cookies   string   `yaml:"CookieFile,omitempty"`       // login session kept across restarts
+	CredFile  string   `yaml:"CredentialsFile,omitempty"`  // account logins, see Credentials
+	RetryMin  uint     `yaml:"RetryMinutes,omitempty"`     // first delay after a failed update
+	RetryMax  uint     `yaml:"MaxRetryMinutes,omitempty"`  // longest delay between failed updates
+	Flock     bool     `yaml:"AdvisoryLocks,omitempty"`    // also flock the .lck files
+	Devices   []Device `yaml:"Devices"`
+
+	// Server connection, empty or 0 for the defaults.
+	PrimenetURL string `yaml:"PrimenetURL,omitempty"`
+	GPU72URL    string `yaml:"GPU72URL,omitempty"`
+	V5URL       string `yaml:"PrimenetV5URL,omitempty"`
+	Proxy       string `yaml:"Proxy,omitempty"`          // http, https or socks5 proxy URL
+	CABundle    string `yaml:"CABundle,omitempty"`       // PEM file of extra trusted certificate authorities
+	TimeoutSec  uint   `yaml:"TimeoutSeconds,omitempty"` // limit on each request
+}
+
+// Device is the configuration of a single mfakto or clLucas instance.
+//
+// Kind names the worker program (mfakto or clLucas) and is only required
+// by the combined manager.  WorkType is a trial factoring type (lltf or dctf) for mfakto and a
+// Primenet work preference code (100, 101, 102) for clLucas.
+type Device struct {
+	Name       string `yaml:"Name,omitempty"` // selects the device in -device overrides
+	Kind       string `yaml:"Kind,omitempty"`
+	Device     uint   `yaml:"Device"`
+	Workdir    string `yaml:"Directory"`
+	WorkType   string `yaml:"WorkType"`
+	WorkOption string `yaml:"WorkOption,omitempty"`
+	Target     uint   `yaml:"TargetExponent,omitempty"`
+	Cache      uint   `yaml:"Assignments"`
+	GpuTh      uint   `yaml:"Threads,omitempty"`
+	FFTSmooth  []uint `yaml:"FFTSmooth,omitempty"` // clLucas FFT sizes allowed: any of 2, 3, 5, 7
+
+	// Exec is the worker program the manager runs in Workdir, with Args
+	// added after the device and thread arguments.  Leave it empty to
+	// run mfakto/clLucas separately.
+	Exec string   `yaml:"Executable,omitempty"`
+	Args []string `yaml:"Args,omitempty"`
+}
+
+// Primenet reports whether mersenne.org credentials are configured.
+func (s *Settings) Primenet() bool {
+	return s.Usrname != "" && s.Pass != ""
+}
+
+// GPU72 reports whether gpu72.com credentials are configured.
+func (s *Settings) GPU72() bool {
+	return s.GPU72Usr != "" && s.GPU72Pass != ""
+}
+
+// Poll returns the polling delay, at most MaxPoll hours.  The settings
+// check reports a longer Polltime; it is left as set.
+func (s *Settings) Poll() time.Duration {
+	hours := s.Polltime
+	if hours > MaxPoll {
+		hours = MaxPoll
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// Default retry delays, in minutes, used when RetryMin or RetryMax is 0.
+const (
+	DefaultRetryMin = 2
+	DefaultRetryMax = 60
+)
+
+// Retry returns the shortest and longest delays between attempts while
+// updates are failing.
+func (s *Settings) Retry() (min, max time.Duration) {
+	lo, hi := s.RetryMin, s.RetryMax
+	if lo == 0 {
+		lo = DefaultRetryMin
+	}
+	if hi == 0 {
+		hi = DefaultRetryMax
+	}
+	if hi < lo {
+		hi = lo
+	}
+	return time.Duration(lo) * time.Minute, time.Duration(hi) * time.Minute
+}
+
+// DefaultTimeout is the request timeout, in seconds, used when TimeoutSec is 0.
+const DefaultTimeout = 30
+
+// Timeout returns the time limit on each request to the servers.
+func (s *Settings) Timeout() time.Duration {
+	if s.TimeoutSec == 0 {
+		return DefaultTimeout * time.Second
+	}
+	return time.Duration(s.TimeoutSec) * time.Second
+}
+
+// Load reads the yaml file at path over the values already in sett.
+// Unknown keys are errors, and yaml errors are FileErrors.
+func Load(path string, sett *Settings) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	contents, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	if err := yaml.UnmarshalStrict(contents, sett); err != nil {
+		return fileErrors(path, contents, err)
+	}
+	return nil
+}
+
+// Write stores sett as yaml at path.  Passwords are left out; they belong
+// in the credentials file or the environment.
+func Write(path string, sett *Settings) error {
+	s := *sett
+	s.Pass, s.GPU72Pass = "", ""
+	st, err := yaml.Marshal(&s)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, st, 0664)
+}
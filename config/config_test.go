@@ -0,0 +1,167 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.yml")
+	want := Settings{
+		Usrname:  "user",
+		Pass:     "secret",
+		Polltime: 6,
+		Ledger:   "ledger.db",
+		Devices: []Device{
+			{Name: "gpu0", Workdir: "a", WorkType: "lltf", Target: 74, Cache: 5},
+			{Kind: "clLucas", Device: 1, Workdir: "b", WorkType: "101", FFTSmooth: []uint{2, 3}},
+		},
+	}
+	if err := Write(path, &want); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("password written to the settings file:\n%s", data)
+	}
+
+	var got Settings
+	if err := Load(path, &got); err != nil {
+		t.Fatal(err)
+	}
+	want.Pass = ""
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loaded %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, test := range []struct {
+		yaml string
+		want string
+	}{
+		{"UserName: a\nPoll: six\n", "settings.yml:2: Poll: cannot unmarshal"},
+		{"UserName: a\nPol: 2\n", "settings.yml:2: Pol: field Pol not found"},
+		{"Devices:\n- Directory: .\n  Assignments: [1]\n", "settings.yml:3: Assignments: cannot unmarshal"},
+		{"Devices:\n  - Directory: .\n bad: [\n", "settings.yml:"},
+	} {
+		path := filepath.Join(t.TempDir(), "settings.yml")
+		ioutil.WriteFile(path, []byte(test.yaml), 0644)
+		var s Settings
+		err := Load(path, &s)
+		var fe *FileError
+		if !errors.As(err, &fe) {
+			t.Errorf("%q: err = %v, want a FileError", test.yaml, err)
+			continue
+		}
+		if got := filepath.Base(fe.Path) + strings.TrimPrefix(err.Error(), fe.Path); !strings.HasPrefix(got, test.want) {
+			t.Errorf("%q: err = %q, want prefix %q", test.yaml, got, test.want)
+		}
+	}
+}
+
+func TestLoadFiles(t *testing.T) {
+	dir := t.TempDir()
+	sys, user, local := filepath.Join(dir, "sys.yml"), filepath.Join(dir, "user.yml"), filepath.Join(dir, "local.yml")
+	ioutil.WriteFile(sys, []byte("Poll: 1\nProxy: http://proxy:3128\nLogs: sys.log\n"), 0644)
+	ioutil.WriteFile(local, []byte("Poll: 3\n"), 0644)
+	s := Settings{Polltime: 12, Ledger: "default.db"}
+	if err := LoadFiles(&s, sys, user, local); err != nil {
+		t.Fatal(err)
+	}
+	if s.Polltime != 3 || s.Proxy != "http://proxy:3128" || s.LogFile != "sys.log" || s.Ledger != "default.db" {
+		t.Errorf("layered settings = %+v", s)
+	}
+
+	defer func(dir string) { SystemDir = dir }(SystemDir)
+	SystemDir = "/sys"
+	files := Layers("TFsettings.yml", "")
+	if files[0] != filepath.Join("/sys", "TFsettings.yml") || files[len(files)-1] != "TFsettings.yml" {
+		t.Errorf("Layers = %v", files)
+	}
+	if files := Layers("TFsettings.yml", "my.yml"); files[len(files)-1] != "my.yml" {
+		t.Errorf("Layers with -config = %v", files)
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	t.Setenv("MM_POLL", "7")
+	t.Setenv("MM_PROXY", "socks5://h:1")
+	t.Setenv("MM_ADVISORYLOCKS", "true")
+	t.Setenv("MM_PASSWORD", "ignored")
+	var s Settings
+	if err := s.LoadEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Polltime != 7 || s.Proxy != "socks5://h:1" || !s.Flock || s.Pass != "" {
+		t.Errorf("settings from environment = %+v", s)
+	}
+	t.Setenv("MM_POLL", "often")
+	if err := s.LoadEnv(); err == nil || !strings.Contains(err.Error(), "MM_POLL") {
+		t.Errorf("bad MM_POLL: err = %v", err)
+	}
+}
+
+func TestSetDevice(t *testing.T) {
+	s := Settings{Devices: []Device{{Workdir: "a"}, {Name: "gpu1", Workdir: "b"}}}
+	for _, spec := range []string{"0:Assignments=9", "gpu1:directory=/work/b", "1:FFTSmooth=2, 3", "0:Args=-v,-d"} {
+		if err := s.SetDevice(spec); err != nil {
+			t.Errorf("SetDevice(%q): %v", spec, err)
+		}
+	}
+	if s.Devices[0].Cache != 9 || s.Devices[1].Workdir != "/work/b" ||
+		!reflect.DeepEqual(s.Devices[1].FFTSmooth, []uint{2, 3}) || !reflect.DeepEqual(s.Devices[0].Args, []string{"-v", "-d"}) {
+		t.Errorf("devices = %+v", s.Devices)
+	}
+	for _, spec := range []string{"2:Assignments=1", "gpu2:Assignments=1", "0:Foo=1", "0:Assignments=-1", "Assignments=1", "0:Assignments"} {
+		if err := s.SetDevice(spec); err == nil {
+			t.Errorf("SetDevice(%q): no error", spec)
+		}
+	}
+}
+
+func TestFlagValue(t *testing.T) {
+	for _, test := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "def"},
+		{[]string{"-config", "a.yml"}, "a.yml"},
+		{[]string{"-usr", "me", "--config=b.yml", "status"}, "b.yml"},
+		{[]string{"config", "c.yml"}, "def"},
+		{[]string{"--", "-config", "d.yml"}, "def"},
+	} {
+		if got := FlagValue(test.args, "config", "def"); got != test.want {
+			t.Errorf("FlagValue(%q) = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+func TestDurations(t *testing.T) {
+	s := Settings{Polltime: 500}
+	if s.Poll() != MaxPoll*time.Hour {
+		t.Errorf("Poll() = %v, want the %d hour cap", s.Poll(), MaxPoll)
+	}
+	if s.Polltime != 500 {
+		t.Errorf("Poll() changed Polltime to %d", s.Polltime)
+	}
+	if min, max := s.Retry(); min != DefaultRetryMin*time.Minute || max != DefaultRetryMax*time.Minute {
+		t.Errorf("default Retry() = %v, %v", min, max)
+	}
+	s.RetryMin, s.RetryMax = 30, 10
+	if min, max := s.Retry(); min != 30*time.Minute || max != 30*time.Minute {
+		t.Errorf("Retry() with max below min = %v, %v", min, max)
+	}
+	if s.Timeout() != DefaultTimeout*time.Second {
+		t.Errorf("default Timeout() = %v", s.Timeout())
+	}
+}
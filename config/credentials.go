@@ -0,0 +1,313 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+	"gopkg.in/yaml.v2"
+)
+
+// Environment variables holding account credentials.  They override the
+// settings and credentials files.
+const (
+	EnvUser       = "PRIMENET_USER"
+	EnvPass       = "PRIMENET_PASSWORD"
+	EnvGPU72User  = "GPU72_USER"
+	EnvGPU72Pass  = "GPU72_PASSWORD"
+	EnvPassphrase = "MM_PASSPHRASE" // unlocks an encrypted credentials file
+)
+
+// Credentials are the account logins kept apart from the settings file.
+type Credentials struct {
+	Usrname   string `yaml:"UserName,omitempty"`
+	Pass      string `yaml:"Password,omitempty"`
+	GPU72Usr  string `yaml:"GPU72UserName,omitempty"`
+	GPU72Pass string `yaml:"GPU72Password,omitempty"`
+}
+
+// ErrPermissions is returned for a credentials file that other users can read.
+var ErrPermissions = errors.New("credentials file must not be readable by other users (chmod 600)")
+
+// encMagic starts an encrypted credentials file.
+var encMagic = []byte("MMCRED1\n")
+
+// LoadCredentials fills in the credentials from CredFile, when it exists,
+// and then from the environment.  An encrypted file is unlocked with the
+// passphrase returned by pass.
+func (s *Settings) LoadCredentials(pass func() ([]byte, error)) error {
+	if s.CredFile != "" {
+		c, err := ReadCredentials(s.CredFile, pass)
+		switch {
+		case err == nil:
+			s.apply(c)
+		case !os.IsNotExist(err):
+			return err
+		}
+	}
+	s.apply(Credentials{
+		Usrname:   os.Getenv(EnvUser),
+		Pass:      os.Getenv(EnvPass),
+		GPU72Usr:  os.Getenv(EnvGPU72User),
+		GPU72Pass: os.Getenv(EnvGPU72Pass),
+	})
+	return nil
+}
+
+// apply copies the credentials that are set over those in s.
+func (s *Settings) apply(c Credentials) {
+	set := func(dst *string, src string) {
+		if src != "" {
+			*dst = src
+		}
+	}
+	set(&s.Usrname, c.Usrname)
+	set(&s.Pass, c.Pass)
+	set(&s.GPU72Usr, c.GPU72Usr)
+	set(&s.GPU72Pass, c.GPU72Pass)
+}
+
+// Credentials returns the account logins in s.
+func (s *Settings) Credentials() Credentials {
+	return Credentials{Usrname: s.Usrname, Pass: s.Pass, GPU72Usr: s.GPU72Usr, GPU72Pass: s.GPU72Pass}
+}
+
+// ReadCredentials reads a credentials file, checking that only its owner
+// can read it.  An encrypted file is unlocked with the passphrase returned
+// by pass.
+func ReadCredentials(path string, pass func() ([]byte, error)) (Credentials, error) {
+	var c Credentials
+	fi, err := os.Stat(path)
+	if err != nil {
+		return c, err
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm()&0077 != 0 {
+		return c, fmt.Errorf("%s: mode %04o: %w", path, fi.Mode().Perm(), ErrPermissions)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	if bytes.HasPrefix(data, encMagic) {
+		phrase, err := pass()
+		if err != nil {
+			return c, err
+		}
+		if data, err = decrypt(data[len(encMagic):], phrase); err != nil {
+			return c, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// WriteCredentials stores c at path, readable only by the owner.  With a
+// passphrase the file is encrypted.
+func WriteCredentials(path string, c Credentials, passphrase []byte) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if passphrase != nil {
+		enc, err := encrypt(data, passphrase)
+		if err != nil {
+			return err
+		}
+		data = append(append([]byte(nil), encMagic...), enc...)
+	}
+	// TempFile creates the file 0600, so the credentials are never readable
+	// by others, even for a moment, whatever the mode of the file replaced
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return nil
+}
+
+// Encryption parameters: scrypt derives an AES-256-GCM key from the passphrase.
+const (
+	saltLen           = 16
+	scryptN, scryptR  = 1 << 15, 8
+	scryptP, keyBytes = 1, 32
+)
+
+func encrypt(plain, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := gcm.Seal(append(salt, nonce...), nonce, plain, nil)
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(out, sealed)
+	return append(out, '\n'), nil
+}
+
+func decrypt(data, passphrase []byte) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return nil, fmt.Errorf("corrupt credentials: %w", err)
+	}
+	if len(sealed) < saltLen {
+		return nil, errors.New("corrupt credentials")
+	}
+	gcm, err := newGCM(passphrase, sealed[:saltLen])
+	if err != nil {
+		return nil, err
+	}
+	rest := sealed[saltLen:]
+	if len(rest) < gcm.NonceSize() {
+		return nil, errors.New("corrupt credentials")
+	}
+	plain, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("wrong passphrase or corrupt credentials")
+	}
+	return plain, nil
+}
+
+func newGCM(passphrase, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, keyBytes)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+var passphrase struct {
+	sync.Mutex
+	b []byte
+}
+
+// Passphrase returns the passphrase for an encrypted credentials file from
+// the MM_PASSPHRASE environment variable, or asks for it on the terminal.
+// The answer is remembered for settings reloads.
+func Passphrase() ([]byte, error) {
+	passphrase.Lock()
+	defer passphrase.Unlock()
+	if passphrase.b != nil {
+		return passphrase.b, nil
+	}
+	if p, ok := os.LookupEnv(EnvPassphrase); ok {
+		passphrase.b = []byte(p)
+		return passphrase.b, nil
+	}
+	p, err := ReadPassphrase("Credentials passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	passphrase.b = p
+	return p, nil
+}
+
+// ReadPassphrase prompts for a passphrase on the terminal without echoing it.
+func ReadPassphrase(prompt string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("encrypted credentials: set %s or run from a terminal", EnvPassphrase)
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	p, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return p, err
+}
+
+// SaveCredentials writes the logins in s to CredFile.  With encrypt the file
+// is encrypted with a new passphrase, from MM_PASSPHRASE or the terminal.
+// A file that was already encrypted keeps its passphrase.
+func SaveCredentials(s *Settings, encrypt bool) error {
+	c := s.Credentials()
+	if c.Pass == "" && c.GPU72Pass == "" {
+		return nil
+	}
+	if s.CredFile == "" {
+		return errors.New("no CredentialsFile to store the passwords in")
+	}
+	var (
+		phrase []byte
+		err    error
+	)
+	switch {
+	case encrypt:
+		phrase, err = newPassphrase()
+	case encrypted(s.CredFile):
+		phrase, err = Passphrase()
+	}
+	if err != nil {
+		return err
+	}
+	return WriteCredentials(s.CredFile, c, phrase)
+}
+
+// encrypted reports whether the file at path is an encrypted credentials file.
+func encrypted(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, len(encMagic))
+	n, _ := f.Read(head)
+	return bytes.Equal(head[:n], encMagic)
+}
+
+// newPassphrase asks twice for a new passphrase unless MM_PASSPHRASE is set.
+func newPassphrase() ([]byte, error) {
+	if p, ok := os.LookupEnv(EnvPassphrase); ok {
+		return []byte(p), nil
+	}
+	p, err := ReadPassphrase("New credentials passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	again, err := ReadPassphrase("Repeat passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if len(p) == 0 || !bytes.Equal(p, again) {
+		return nil, errors.New("passphrases are empty or don't match")
+	}
+	return p, nil
+}
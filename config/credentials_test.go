@@ -0,0 +1,88 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestWriteCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.yml")
+	want := Credentials{Usrname: "user", Pass: "secret", GPU72Usr: "g", GPU72Pass: "gsecret"}
+	phrase := func() ([]byte, error) { return []byte("open sesame"), nil }
+	for _, test := range []struct {
+		name       string
+		passphrase []byte
+	}{
+		{"plain", nil},
+		{"encrypted", []byte("open sesame")},
+	} {
+		// A readable file left behind must not stay readable
+		ioutil.WriteFile(path, []byte("old"), 0644)
+		os.Chmod(path, 0644)
+		if err := WriteCredentials(path, want, test.passphrase); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if fi, _ := os.Stat(path); runtime.GOOS != "windows" && fi.Mode().Perm() != 0600 {
+			t.Errorf("%s: mode = %04o, want 0600", test.name, fi.Mode().Perm())
+		}
+		data, _ := ioutil.ReadFile(path)
+		if enc := bytes.HasPrefix(data, encMagic); enc != (test.passphrase != nil) || enc && bytes.Contains(data, []byte("secret")) {
+			t.Errorf("%s: file contents %q", test.name, data)
+		}
+		got, err := ReadCredentials(path, phrase)
+		if err != nil || got != want {
+			t.Errorf("%s: ReadCredentials = %+v, %v", test.name, got, err)
+		}
+		if tmp, _ := filepath.Glob(path + ".tmp*"); len(tmp) > 0 {
+			t.Errorf("%s: temporary files left: %v", test.name, tmp)
+		}
+	}
+
+	_, err := ReadCredentials(path, func() ([]byte, error) { return []byte("wrong"), nil })
+	if err == nil {
+		t.Error("wrong passphrase: no error")
+	}
+}
+
+func TestReadCredentialsPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no Unix file modes")
+	}
+	path := filepath.Join(t.TempDir(), "credentials.yml")
+	ioutil.WriteFile(path, []byte("Password: secret\n"), 0644)
+	os.Chmod(path, 0644)
+	if _, err := ReadCredentials(path, nil); !errors.Is(err, ErrPermissions) {
+		t.Errorf("err = %v, want ErrPermissions", err)
+	}
+}
+
+func TestLoadCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.yml")
+	if err := WriteCredentials(path, Credentials{Usrname: "file", Pass: "filepass", GPU72Usr: "g", GPU72Pass: "gpass"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvUser, "env")
+	t.Setenv(EnvPass, "envpass")
+	s := Settings{Usrname: "settings", CredFile: path}
+	if err := s.LoadCredentials(nil); err != nil {
+		t.Fatal(err)
+	}
+	want := Credentials{Usrname: "env", Pass: "envpass", GPU72Usr: "g", GPU72Pass: "gpass"}
+	if got := s.Credentials(); got != want {
+		t.Errorf("credentials = %+v, want %+v", got, want)
+	}
+
+	s = Settings{CredFile: filepath.Join(filepath.Dir(path), "missing.yml")}
+	if err := s.LoadCredentials(nil); err != nil || s.Usrname != "env" {
+		t.Errorf("missing file: %+v, %v", s.Credentials(), err)
+	}
+}
@@ -0,0 +1,10 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+//go:build !windows
+
+package config
+
+// SystemDir holds the system-wide settings files, read before the user's.
+var SystemDir = "/etc/MersenneManager"
@@ -0,0 +1,20 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// SystemDir holds the system-wide settings files, read before the user's.
+var SystemDir = systemDir()
+
+func systemDir() string {
+	if dir := os.Getenv("ProgramData"); dir != "" {
+		return filepath.Join(dir, "MersenneManager")
+	}
+	return ""
+}
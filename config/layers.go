@@ -0,0 +1,237 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix starts the environment variables that override settings, e.g.
+// MM_POLL or MM_PROXY for Poll and Proxy.
+const EnvPrefix = "MM_"
+
+// Layers returns the settings files read for the file name, lowest
+// precedence first: the system file, the user file and then path.  Path is
+// the -config file, or name in the working directory.
+func Layers(name, path string) []string {
+	var files []string
+	if SystemDir != "" {
+		files = append(files, filepath.Join(SystemDir, name))
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		files = append(files, filepath.Join(dir, "MersenneManager", name))
+	}
+	if path == "" {
+		path = name
+	}
+	return append(files, path)
+}
+
+// LoadFiles reads each file in turn over sett, skipping files that don't
+// exist.
+func LoadFiles(sett *Settings, files ...string) error {
+	for _, f := range files {
+		if err := Load(f, sett); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// FileError is a problem in a settings file.  Line and Field are set when
+// the yaml decoder reports the line.
+type FileError struct {
+	Path  string
+	Line  int
+	Field string
+	Msg   string
+}
+
+func (e *FileError) Error() string {
+	switch {
+	case e.Field != "":
+		return fmt.Sprintf("%s:%d: %s: %s", e.Path, e.Line, e.Field, e.Msg)
+	case e.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Msg)
+}
+
+var yamlLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// fileErrors turns a yaml error for the file contents into FileErrors
+// naming the line and the field on it.
+func fileErrors(path string, contents []byte, err error) error {
+	msgs := []string{err.Error()}
+	var terr *yaml.TypeError
+	if errors.As(err, &terr) {
+		msgs = terr.Errors
+	}
+	lines := strings.Split(string(contents), "\n")
+	var errs []error
+	for _, msg := range msgs {
+		fe := &FileError{Path: path, Msg: strings.TrimPrefix(msg, "yaml: ")}
+		if m := yamlLine.FindStringSubmatch(msg); m != nil {
+			fe.Line, _ = strconv.Atoi(m[1])
+			fe.Msg = m[2]
+			if fe.Line > 0 && fe.Line <= len(lines) {
+				fe.Field = fieldName(lines[fe.Line-1])
+			}
+		}
+		errs = append(errs, fe)
+	}
+	return errors.Join(errs...)
+}
+
+// fieldName returns the key on a yaml line, or "" if it has none.
+func fieldName(line string) string {
+	line = strings.TrimLeft(strings.TrimSpace(line), "- ")
+	i := strings.Index(line, ":")
+	if i <= 0 || strings.ContainsAny(line[:i], " \t\"'{[") {
+		return ""
+	}
+	return line[:i]
+}
+
+// LoadEnv sets the scalar settings named by MM_ environment variables,
+// e.g. MM_POLL=6.  The account credentials have their own variables.
+func (s *Settings) LoadEnv() error {
+	v := reflect.ValueOf(s).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := yamlKey(t.Field(i))
+		if key == "" || credentialKeys[key] {
+			continue
+		}
+		val, ok := os.LookupEnv(EnvPrefix + strings.ToUpper(key))
+		if !ok {
+			continue
+		}
+		if err := setValue(v.Field(i), val); err != nil {
+			return fmt.Errorf("%s%s: %w", EnvPrefix, strings.ToUpper(key), err)
+		}
+	}
+	return nil
+}
+
+var credentialKeys = map[string]bool{
+	"UserName": true, "Password": true, "GPU72UserName": true, "GPU72Password": true,
+}
+
+// SetDevice overrides a device setting from a spec of the form
+// index:Key=value or name:Key=value, where Key is the device's yaml key,
+// e.g. 1:Assignments=10 or gpu1:Directory=/work/gpu1.
+func (s *Settings) SetDevice(spec string) error {
+	sel, assign, ok := strings.Cut(spec, ":")
+	key, val, ok2 := strings.Cut(assign, "=")
+	if !ok || !ok2 {
+		return errors.New("want index:Key=value or name:Key=value")
+	}
+	dev := s.Device(sel)
+	if dev == nil {
+		return fmt.Errorf("no device %s", sel)
+	}
+	v := reflect.ValueOf(dev).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if strings.EqualFold(yamlKey(v.Type().Field(i)), key) {
+			if err := setValue(v.Field(i), val); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown device key %s", key)
+}
+
+// Device returns the device with the Name sel, or at index sel in Devices,
+// or nil if there is none.
+func (s *Settings) Device(sel string) *Device {
+	for i := range s.Devices {
+		if s.Devices[i].Name != "" && s.Devices[i].Name == sel {
+			return &s.Devices[i]
+		}
+	}
+	if i, err := strconv.Atoi(sel); err == nil && i >= 0 && i < len(s.Devices) {
+		return &s.Devices[i]
+	}
+	return nil
+}
+
+// yamlKey returns the yaml key of a struct field, or "" if it has none.
+func yamlKey(f reflect.StructField) string {
+	key, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+	if key == "-" {
+		return ""
+	}
+	return key
+}
+
+// setValue parses s into a string, bool, uint or list field.  Lists are
+// comma separated.
+func setValue(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Uint:
+		n, err := strconv.ParseUint(s, 10, 0)
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Slice:
+		var parts []string
+		if s != "" {
+			parts = strings.Split(s, ",")
+		}
+		list := reflect.MakeSlice(v.Type(), len(parts), len(parts))
+		for i, p := range parts {
+			if err := setValue(list.Index(i), strings.TrimSpace(p)); err != nil {
+				return err
+			}
+		}
+		v.Set(list)
+	default:
+		return fmt.Errorf("can't set %s from text", v.Type())
+	}
+	return nil
+}
+
+// FlagValue returns the value given for the flag name in args, in any of
+// the forms the flag package accepts, or def when it isn't given.  It lets
+// -config be found before the other flags are defined.
+func FlagValue(args []string, name, def string) string {
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			break
+		}
+		if !strings.HasPrefix(a, "-") {
+			continue
+		}
+		a = strings.TrimPrefix(strings.TrimPrefix(a, "-"), "-")
+		if a == name && i+1 < len(args) {
+			return args[i+1]
+		}
+		if v, ok := strings.CutPrefix(a, name+"="); ok {
+			return v
+		}
+	}
+	return def
+}
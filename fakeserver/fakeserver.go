@@ -0,0 +1,173 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+// Package fakeserver runs in-process imitations of the mersenne.org manual
+// pages, the PrimeNet v5 server and GPU72's assignment pages, for
+// end-to-end tests of the managers against temporary work directories.
+//
+// Each fake hands out the assignments queued with AddWork, answers results
+// and unreserve requests the way the real pages do, and can be scripted
+// with Faults to return errors, hang until the client times out, or drop
+// the connection.  Point a client at a fake by setting its BaseURL:
+//
+//	pn := fakeserver.NewPrimenet("user", "pass")
+//	defer pn.Close()
+//	c := primenet.New("user", "pass")
+//	c.BaseURL = pn.BaseURL()
+package fakeserver
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Kunde21/MersenneManager/worktodo"
+)
+
+// Fault replaces or delays the normal answer to one request.  It reports
+// whether it wrote the response; if not, the page is served as usual.
+type Fault func(w http.ResponseWriter, r *http.Request) (handled bool)
+
+// Status answers with the HTTP status code.
+func Status(code int) Fault {
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		http.Error(w, http.StatusText(code), code)
+		return true
+	}
+}
+
+// Reply answers with body in place of the page.
+func Reply(body string) Fault {
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		w.Write([]byte(body))
+		return true
+	}
+}
+
+// Delay serves the page after d, or not at all if the client gives up
+// first.
+func Delay(d time.Duration) Fault {
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-r.Context().Done():
+			return true
+		case <-t.C:
+			return false
+		}
+	}
+}
+
+// Hang never answers; the request lasts until the client times out or
+// the server is closed.
+func Hang(w http.ResponseWriter, r *http.Request) bool {
+	// The server only notices the client going away once the body is read
+	io.Copy(ioutil.Discard, r.Body)
+	<-r.Context().Done()
+	return true
+}
+
+// Hangup closes the connection without answering.
+func Hangup(w http.ResponseWriter, r *http.Request) bool {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		panic("fakeserver: connection can't be hijacked")
+	}
+	conn, _, err := hj.Hijack()
+	if err == nil {
+		conn.Close()
+	}
+	return true
+}
+
+// server is the scripting shared by the fakes.
+type server struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	faults map[string][]Fault
+	hits   map[string]int
+	work   map[string][]worktodo.Entry // queued assignments by work type
+}
+
+func newServer(pages map[string]http.HandlerFunc) *server {
+	s := &server{
+		faults: make(map[string][]Fault),
+		hits:   make(map[string]int),
+		work:   make(map[string][]worktodo.Entry),
+	}
+	mux := http.NewServeMux()
+	for page, h := range pages {
+		mux.Handle(page, s.script(page, h))
+	}
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// script wraps the handler of page with the queued faults.
+func (s *server) script(page string, h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.hits[page]++
+		var f Fault
+		if q := s.faults[page]; len(q) > 0 {
+			f, s.faults[page] = q[0], q[1:]
+		}
+		s.mu.Unlock()
+		if f != nil && f(w, r) {
+			return
+		}
+		h(w, r)
+	})
+}
+
+// Script queues faults for the next requests to page, one per request.
+// A nil Fault serves that request normally.  page is the path pattern of
+// the page, as listed on NewPrimenet and NewGPU72, or the V5 transaction.
+func (s *server) Script(page string, faults ...Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[page] = append(s.faults[page], faults...)
+}
+
+// Hits returns the number of requests made to page.
+func (s *server) Hits(page string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits[page]
+}
+
+// BaseURL returns the address to use as a client's BaseURL.
+func (s *server) BaseURL() *url.URL {
+	u, _ := url.Parse(s.URL + "/")
+	return u
+}
+
+// Close shuts the server down, ending requests left hanging by faults.
+func (s *server) Close() {
+	s.CloseClientConnections()
+	s.Server.Close()
+}
+
+// queue adds assignments of workType to be handed out.
+func (s *server) queue(workType string, work ...worktodo.Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.work[workType] = append(s.work[workType], work...)
+}
+
+// take removes up to n queued assignments of workType.  s.mu must be held.
+func (s *server) take(workType string, n int) []worktodo.Entry {
+	q := s.work[workType]
+	if n > len(q) {
+		n = len(q)
+	}
+	s.work[workType] = q[n:]
+	return q[:n]
+}
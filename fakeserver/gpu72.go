@@ -0,0 +1,114 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package fakeserver
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Kunde21/MersenneManager/worktodo"
+)
+
+// GPU72 pages, for Script and Hits.
+const (
+	GPU72GetAssignments = "/account/getassignments/"
+	GPU72Assignments    = "/account/assignments/"
+)
+
+// GPU72 imitates the GPU72 get assignments and view assignments pages.
+// Assignments are queued by work type (lltf or dctf) and are raised to the
+// pledged bit level when handed out.
+type GPU72 struct {
+	*server
+	User, Pass string
+
+	reserved []worktodo.Entry
+}
+
+// NewGPU72 starts a fake GPU72 server with one account.
+func NewGPU72(user, pass string) *GPU72 {
+	g := &GPU72{User: user, Pass: pass}
+	g.server = newServer(map[string]http.HandlerFunc{
+		GPU72GetAssignments: g.getAssignments,
+		GPU72Assignments:    g.assignments,
+	})
+	return g
+}
+
+// AddWork queues trial factoring assignments for workType.  Assignments
+// without an AID are given one.
+func (g *GPU72) AddWork(workType string, work ...worktodo.Entry) {
+	for i := range work {
+		work[i].Kind = worktodo.Factor
+		if work[i].AID == "" {
+			work[i].AID = NewAID()
+		}
+	}
+	g.queue(workType, work...)
+}
+
+// Reserved returns the assignments handed out so far.
+func (g *GPU72) Reserved() []worktodo.Entry {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]worktodo.Entry(nil), g.reserved...)
+}
+
+// authorized checks the basic auth credentials, answering 401 if they
+// are wrong.
+func (g *GPU72) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if u, p, ok := r.BasicAuth(); ok && u == g.User && p == g.Pass {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="GPU72"`)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	return false
+}
+
+func (g *GPU72) getAssignments(w http.ResponseWriter, r *http.Request) {
+	if !g.authorized(w, r) {
+		return
+	}
+	r.ParseForm()
+	workType := strings.Trim(strings.TrimPrefix(r.URL.Path, GPU72GetAssignments), "/")
+	n, _ := strconv.Atoi(r.PostForm.Get("Number"))
+	pledge, _ := strconv.ParseUint(r.PostForm.Get("Pledge"), 10, 0)
+
+	g.mu.Lock()
+	work := g.take(workType, n)
+	for i := range work {
+		if work[i].BitHi < uint(pledge) {
+			work[i].BitHi = uint(pledge)
+		}
+	}
+	g.reserved = append(g.reserved, work...)
+	g.mu.Unlock()
+
+	// The real page lists each assignment twice
+	fmt.Fprint(w, "<html><body>\n")
+	for _, e := range work {
+		fmt.Fprintf(w, "<tr><td>%s</td></tr>\n", e)
+	}
+	fmt.Fprint(w, "<textarea>\n")
+	for _, e := range work {
+		fmt.Fprintln(w, e)
+	}
+	fmt.Fprint(w, "</textarea></body></html>")
+}
+
+func (g *GPU72) assignments(w http.ResponseWriter, r *http.Request) {
+	if !g.authorized(w, r) {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	fmt.Fprint(w, "<html><body><table>\n")
+	for _, e := range g.reserved {
+		fmt.Fprintf(w, "<tr><td>%d</td><td>%d</td><td>%d</td><td>%s</td></tr>\n", e.Exponent, e.BitLo, e.BitHi, e.AID)
+	}
+	fmt.Fprint(w, "</table></body></html>")
+}
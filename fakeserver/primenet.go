@@ -0,0 +1,256 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package fakeserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Kunde21/MersenneManager/primenet"
+	"github.com/Kunde21/MersenneManager/results"
+	"github.com/Kunde21/MersenneManager/worktodo"
+)
+
+// Primenet pages, for Script and Hits.
+const (
+	PrimenetLogin      = "/"
+	PrimenetAssignment = "/manual_assignment/"
+	PrimenetResult     = "/manual_result/"
+	PrimenetWorkload   = "/workload/"
+)
+
+// PrimenetUnreserve is the manual unreserve page.
+var PrimenetUnreserve = primenet.UnreservePath
+
+// loginForm is shown on every page in place of the account details when
+// the request has no valid session.
+const (
+	loginForm = `<form method="post" action="/">` +
+		`<input type="text" name="user_login"><input type="password" name="user_password">` +
+		`</form>`
+	loginPage = "<html><body>" + loginForm + "</body></html>"
+)
+
+// Primenet imitates the mersenne.org login, manual assignment, manual
+// result, unreserve and workload pages.  Assignments are queued by
+// work preference code (primenet.TrialFactoring, primenet.DoubleCheck...)
+// and are only handed out to a logged in session.
+type Primenet struct {
+	*server
+	User, Pass string
+	Credit     float64 // GHz-days credited for each accepted result
+
+	sessions map[string]bool
+	reserved []worktodo.Entry
+	results  map[string]bool // result lines accepted
+	verdicts map[uint64]string
+}
+
+// NewPrimenet starts a fake Primenet server with one account.
+func NewPrimenet(user, pass string) *Primenet {
+	p := &Primenet{
+		User:     user,
+		Pass:     pass,
+		Credit:   1,
+		sessions: make(map[string]bool),
+		results:  make(map[string]bool),
+		verdicts: make(map[uint64]string),
+	}
+	p.server = newServer(map[string]http.HandlerFunc{
+		PrimenetLogin:      p.login,
+		PrimenetAssignment: p.assignment,
+		PrimenetResult:     p.result,
+		PrimenetUnreserve:  p.unreserve,
+		PrimenetWorkload:   p.workload,
+	})
+	return p
+}
+
+// AddWork queues assignments for the work preference code pref.
+// Assignments without an AID are given one.
+func (p *Primenet) AddWork(pref uint, work ...worktodo.Entry) {
+	for i := range work {
+		if work[i].AID == "" {
+			work[i].AID = NewAID()
+		}
+	}
+	p.queue(fmt.Sprint(pref), work...)
+}
+
+// Reserve lists assignments as reserved to the account without handing
+// them out, as if they had been fetched by another computer.
+func (p *Primenet) Reserve(work ...worktodo.Entry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reserved = append(p.reserved, work...)
+}
+
+// Reserved returns the assignments currently reserved to the account.
+func (p *Primenet) Reserved() []worktodo.Entry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]worktodo.Entry(nil), p.reserved...)
+}
+
+// Results returns the result lines accepted so far.
+func (p *Primenet) Results() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var lines []string
+	for l := range p.results {
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+// Verdict makes the result page answer results for exponent with msg, e.g.
+// "Error code: 40, error string: No assignment", in place of a credit.
+func (p *Primenet) Verdict(exponent uint64, msg string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.verdicts[exponent] = msg
+}
+
+// Expire ends every login session, as the server does after a while.
+func (p *Primenet) Expire() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sessions = make(map[string]bool)
+}
+
+// loggedIn reports whether r carries a session cookie.  p.mu must be held.
+func (p *Primenet) loggedIn(r *http.Request) bool {
+	c, err := r.Cookie("session")
+	return err == nil && p.sessions[c.Value]
+}
+
+func (p *Primenet) login(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		fmt.Fprint(w, loginPage)
+		return
+	}
+	r.ParseForm()
+	if r.PostForm.Get("user_login") != p.User || r.PostForm.Get("user_password") != p.Pass {
+		fmt.Fprint(w, "<html><body>Login FAILED"+loginForm+"</body></html>")
+		return
+	}
+	id := NewAID()
+	p.mu.Lock()
+	p.sessions[id] = true
+	p.mu.Unlock()
+	http.SetCookie(w, &http.Cookie{Name: "session", Value: id, Path: "/"})
+	fmt.Fprintf(w, "<html><body>%s<br>logged in</body></html>", p.User)
+}
+
+func (p *Primenet) assignment(w http.ResponseWriter, r *http.Request) {
+	n, _ := strconv.Atoi(r.FormValue("num_to_get"))
+	p.mu.Lock()
+	if !p.loggedIn(r) {
+		p.mu.Unlock()
+		fmt.Fprint(w, loginPage)
+		return
+	}
+	work := p.take(r.FormValue("pref"), n)
+	p.reserved = append(p.reserved, work...)
+	p.mu.Unlock()
+
+	fmt.Fprint(w, "<html><body><pre>\n")
+	for _, e := range work {
+		fmt.Fprintln(w, e)
+	}
+	fmt.Fprint(w, "</pre></body></html>")
+}
+
+func (p *Primenet) result(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.loggedIn(r) {
+		fmt.Fprint(w, loginPage)
+		return
+	}
+	fmt.Fprint(w, "<html><body>\n")
+	parsed, bad := 0, 0
+	for _, line := range strings.Split(r.PostForm.Get("data"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		res, err := results.Parse(line)
+		if err != nil {
+			bad++
+			continue
+		}
+		parsed++
+		fmt.Fprintf(w, "processing: %s result for M%d<br>\n", res.Type, res.Exponent)
+		switch msg, ok := p.verdicts[res.Exponent]; {
+		case ok:
+			fmt.Fprintf(w, "%s<br>\n", msg)
+		case p.results[line]:
+			fmt.Fprint(w, "Error: result already in the database.<br>\n")
+		default:
+			p.results[line] = true
+			if res.Final() {
+				p.release(res.Exponent)
+			}
+			fmt.Fprintf(w, "CPU credit is %.4f GHz-days.<br>\n", p.Credit)
+		}
+	}
+	if bad > 0 {
+		fmt.Fprintf(w, "Did not understand %d lines.<br>\n", bad)
+	}
+	fmt.Fprintf(w, "Done processing: * Parsed %d lines.<br>\n</body></html>", parsed)
+}
+
+// release drops the reservation for exponent.  p.mu must be held.
+func (p *Primenet) release(exponent uint64) bool {
+	for i, e := range p.reserved {
+		if e.Exponent == exponent {
+			p.reserved = append(p.reserved[:i], p.reserved[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Primenet) unreserve(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	e, ok := worktodo.ParseEntry(r.PostForm.Get("data"))
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case !p.loggedIn(r):
+		fmt.Fprint(w, loginPage)
+	case ok && p.release(e.Exponent):
+		fmt.Fprintf(w, "<html><body>M%d assignment unreserved</body></html>", e.Exponent)
+	default:
+		fmt.Fprint(w, "<html><body>No matching assignment</body></html>")
+	}
+}
+
+func (p *Primenet) workload(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.loggedIn(r) {
+		fmt.Fprint(w, loginPage)
+		return
+	}
+	fmt.Fprint(w, "<html><body><pre>\n")
+	for _, e := range p.reserved {
+		fmt.Fprintln(w, e)
+	}
+	fmt.Fprint(w, "</pre></body></html>")
+}
+
+// NewAID returns a random 32 hex digit assignment ID.
+func NewAID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return strings.ToUpper(hex.EncodeToString(b[:]))
+}
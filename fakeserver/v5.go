@@ -0,0 +1,266 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package fakeserver
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Kunde21/MersenneManager/primenet/v5api"
+	"github.com/Kunde21/MersenneManager/worktodo"
+)
+
+// V5 transactions, for Script and Hits.  Every transaction is served from
+// one page, so each is scripted by its t parameter.
+const (
+	V5Register   = "uc"
+	V5Preference = "po"
+	V5Assignment = "ga"
+	V5Progress   = "ap"
+	V5Result     = "ar"
+	V5Unreserve  = "au"
+)
+
+// V5 imitates the PrimeNet v5 server.  Computers must register (uc) before
+// any other transaction.  Assignments are queued by work preference code
+// and handed out one per ga to computers with that preference.
+type V5 struct {
+	*server
+	User string
+
+	computers map[string]uint           // work preference by registered GUID
+	reserved  map[string]worktodo.Entry // by AID
+	results   []string                  // result lines accepted
+	verdicts  map[uint64]int
+}
+
+// NewV5 starts a fake v5 server with one account.
+func NewV5(user string) *V5 {
+	v := &V5{
+		User:      user,
+		computers: make(map[string]uint),
+		reserved:  make(map[string]worktodo.Entry),
+		verdicts:  make(map[uint64]int),
+	}
+	pages := map[string]http.HandlerFunc{
+		V5Register:   v.register,
+		V5Preference: v.preference,
+		V5Assignment: v.assignment,
+		V5Progress:   v.progress,
+		V5Result:     v.result,
+		V5Unreserve:  v.unreserve,
+	}
+	var s *server
+	s = newServer(map[string]http.HandlerFunc{
+		"/": func(w http.ResponseWriter, r *http.Request) {
+			t := r.FormValue("t")
+			h, ok := pages[t]
+			if !ok {
+				reply(w, v5api.ErrorInvalidParameter, "unknown transaction "+t)
+				return
+			}
+			s.script(t, h).ServeHTTP(w, r)
+		},
+	})
+	v.server = s
+	return v
+}
+
+// AddWork queues assignments for the work preference code pref.
+// Assignments without an AID are given one.
+func (v *V5) AddWork(pref uint, work ...worktodo.Entry) {
+	for i := range work {
+		if work[i].AID == "" {
+			work[i].AID = NewAID()
+		}
+	}
+	v.queue(fmt.Sprint(pref), work...)
+}
+
+// Computers returns the number of computers registered to the account.
+func (v *V5) Computers() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return len(v.computers)
+}
+
+// Reserved returns the assignments handed out and not yet completed.
+func (v *V5) Reserved() []worktodo.Entry {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var work []worktodo.Entry
+	for _, e := range v.reserved {
+		work = append(work, e)
+	}
+	return work
+}
+
+// Results returns the result lines accepted so far.
+func (v *V5) Results() []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return append([]string(nil), v.results...)
+}
+
+// Verdict makes ar answer results for exponent with the error code, e.g.
+// v5api.ErrorWorkNoLongerNeeded.
+func (v *V5) Verdict(exponent uint64, code int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.verdicts[exponent] = code
+}
+
+// Forget drops every registered computer, so their next transaction is
+// answered with v5api.ErrorUnregisteredCPU.  Reservations are kept.
+func (v *V5) Forget() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.computers = make(map[string]uint)
+}
+
+// V5Error answers a v5 transaction with the error code, e.g.
+// v5api.ErrorServerBusy.
+func V5Error(code int) Fault {
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		reply(w, code, "scripted error")
+		return true
+	}
+}
+
+// reply writes a v5 response with the error code and key/value pairs.
+func reply(w http.ResponseWriter, code int, detail string, kv ...string) {
+	fmt.Fprintf(w, "pnErrorResult=%d\npnErrorDetail=%s\n", code, detail)
+	for i := 0; i+1 < len(kv); i += 2 {
+		fmt.Fprintf(w, "%s=%s\n", kv[i], kv[i+1])
+	}
+	fmt.Fprint(w, "==END==\n")
+}
+
+// registered answers ErrorUnregisteredCPU unless the request's GUID has
+// been registered.  v.mu must be held.
+func (v *V5) registered(w http.ResponseWriter, r *http.Request) bool {
+	if _, ok := v.computers[r.FormValue("g")]; ok {
+		return true
+	}
+	reply(w, v5api.ErrorUnregisteredCPU, "Server does not recognize the computer GUID")
+	return false
+}
+
+func (v *V5) register(w http.ResponseWriter, r *http.Request) {
+	g := r.FormValue("g")
+	if r.FormValue("u") != v.User {
+		reply(w, v5api.ErrorInvalidUser, "Invalid user")
+		return
+	}
+	if len(g) != 32 {
+		reply(w, v5api.ErrorInvalidParameter, "Invalid computer GUID")
+		return
+	}
+	v.mu.Lock()
+	if _, ok := v.computers[g]; !ok {
+		v.computers[g] = 0
+	}
+	v.mu.Unlock()
+	reply(w, v5api.ErrorOK, "SUCCESS", "g", g)
+}
+
+func (v *V5) preference(w http.ResponseWriter, r *http.Request) {
+	pref, err := strconv.ParseUint(r.FormValue("w"), 10, 0)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	switch {
+	case !v.registered(w, r):
+	case err != nil:
+		reply(w, v5api.ErrorInvalidParameter, "Invalid work preference")
+	default:
+		v.computers[r.FormValue("g")] = uint(pref)
+		reply(w, v5api.ErrorOK, "SUCCESS")
+	}
+}
+
+func (v *V5) assignment(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !v.registered(w, r) {
+		return
+	}
+	work := v.take(fmt.Sprint(v.computers[r.FormValue("g")]), 1)
+	if len(work) == 0 {
+		reply(w, v5api.ErrorNoAssignment, "No assignment available")
+		return
+	}
+	e := work[0]
+	v.reserved[e.AID] = e
+	p1 := "0"
+	if e.PM1Done {
+		p1 = "1"
+	}
+	reply(w, v5api.ErrorOK, "SUCCESS",
+		"k", e.AID, "w", fmt.Sprint(workType(e.Kind)), "n", fmt.Sprint(e.Exponent),
+		"sf", fmt.Sprint(e.BitLo), "ef", fmt.Sprint(e.BitHi), "p1", p1)
+}
+
+// workType returns the v5 work type code of a worktodo entry kind.
+func workType(kind string) int {
+	switch kind {
+	case worktodo.Factor:
+		return v5api.WorkFactor
+	case worktodo.DoubleCheck:
+		return v5api.WorkDoubleCheck
+	case worktodo.PRP:
+		return v5api.WorkPRP
+	}
+	return v5api.WorkFirstLL
+}
+
+func (v *V5) progress(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !v.registered(w, r) {
+		return
+	}
+	if _, ok := v.reserved[r.FormValue("k")]; !ok {
+		reply(w, v5api.ErrorInvalidAssignment, "Invalid assignment key")
+		return
+	}
+	reply(w, v5api.ErrorOK, "SUCCESS")
+}
+
+func (v *V5) result(w http.ResponseWriter, r *http.Request) {
+	aid := r.FormValue("k")
+	exp, _ := strconv.ParseUint(r.FormValue("n"), 10, 64)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !v.registered(w, r) {
+		return
+	}
+	if code, ok := v.verdicts[exp]; ok {
+		reply(w, code, "Result refused")
+		return
+	}
+	if _, ok := v.reserved[aid]; !ok && aid != "" {
+		reply(w, v5api.ErrorInvalidAssignment, "Invalid assignment key")
+		return
+	}
+	delete(v.reserved, aid)
+	v.results = append(v.results, r.FormValue("m"))
+	reply(w, v5api.ErrorOK, "SUCCESS")
+}
+
+func (v *V5) unreserve(w http.ResponseWriter, r *http.Request) {
+	aid := r.FormValue("k")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !v.registered(w, r) {
+		return
+	}
+	if _, ok := v.reserved[aid]; !ok {
+		reply(w, v5api.ErrorInvalidAssignment, "Invalid assignment key")
+		return
+	}
+	delete(v.reserved, aid)
+	reply(w, v5api.ErrorOK, "SUCCESS")
+}
@@ -0,0 +1,112 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+// Package fft chooses FFT lengths for Lucas-Lehmer tests in clLucas.
+//
+// Lengths are multiples of 1K whose largest prime factor is 2, 3, 5 or 7,
+// so a device can be limited to the smooth sizes its FFT library runs
+// fastest.  An exponent fits a length while the bits stored in each FFT
+// word stay under a limit that shrinks as the length grows.
+package fft
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Length range considered, in FFT words.
+const (
+	MinLength = 1 << 10
+	MaxLength = 1 << 26
+)
+
+// Bits per word limit: BPWBase - BPWSlope*log2(length).  The defaults
+// follow the double precision limits used by Prime95 and CUDALucas,
+// about 22.5 bits at 32K and 18.3 at 4M.
+var (
+	BPWBase  = 31.5
+	BPWSlope = 0.6
+)
+
+// Smooth reports the largest prime factor of n if it is at most 7, or 0.
+func Smooth(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	largest := 1
+	for _, p := range []int{2, 3, 5, 7} {
+		for n%p == 0 {
+			n /= p
+			largest = p
+		}
+	}
+	if n != 1 {
+		return 0
+	}
+	return largest
+}
+
+// BitsPerWord returns the largest average number of exponent bits that a
+// length can hold without risking round-off errors.
+func BitsPerWord(length int) float64 {
+	return BPWBase - BPWSlope*math.Log2(float64(length))
+}
+
+// MaxExponent returns the largest exponent that fits length.
+func MaxExponent(length int) uint64 {
+	return uint64(BitsPerWord(length) * float64(length))
+}
+
+// Fits reports whether exponent can be tested with length.
+func Fits(exponent uint64, length int) bool {
+	return float64(exponent)/float64(length) <= BitsPerWord(length)
+}
+
+// Table returns every length between MinLength and MaxLength, in
+// increasing order, whose largest prime factor is in smooth.  An empty
+// smooth allows all 7-smooth lengths.
+func Table(smooth []uint) []int {
+	allow := make(map[int]bool)
+	for _, s := range smooth {
+		allow[int(s)] = true
+	}
+	var table []int
+	for a := MinLength; a <= MaxLength; a *= 2 {
+		for b := a; b <= MaxLength; b *= 3 {
+			for c := b; c <= MaxLength; c *= 5 {
+				for d := c; d <= MaxLength; d *= 7 {
+					if len(allow) == 0 || allow[Smooth(d)] {
+						table = append(table, d)
+					}
+				}
+			}
+		}
+	}
+	sort.Ints(table)
+	return table
+}
+
+// Candidates returns the lengths from table that fit exponent, smallest first.
+func Candidates(exponent uint64, table []int) []int {
+	i := sort.Search(len(table), func(i int) bool { return Fits(exponent, table[i]) })
+	return table[i:]
+}
+
+// Select returns the smallest length in the smooth classes that fits exponent.
+func Select(exponent uint64, smooth []uint) (int, error) {
+	c := Candidates(exponent, Table(smooth))
+	if len(c) == 0 {
+		return 0, fmt.Errorf("fft: no %v-smooth length fits M%d", smooth, exponent)
+	}
+	return c[0], nil
+}
+
+// Format writes length in the K notation accepted by clLucas' -f option.
+func Format(length int) string {
+	if length%1024 == 0 {
+		return fmt.Sprintf("%dK", length/1024)
+	}
+	return fmt.Sprint(length)
+}
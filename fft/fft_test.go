@@ -0,0 +1,96 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package fft
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestSmooth(t *testing.T) {
+	for _, test := range []struct {
+		n, want int
+	}{
+		{0, 0}, {-8, 0}, {1, 1}, {1024, 2}, {3 << 10, 3}, {5 * 3 << 10, 5},
+		{7 * 5 << 10, 7}, {11 << 10, 0}, {13, 0},
+	} {
+		if got := Smooth(test.n); got != test.want {
+			t.Errorf("Smooth(%d) = %d, want %d", test.n, got, test.want)
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	for _, test := range []struct {
+		smooth []uint
+		n      int // lengths expected, 0 to skip the count
+	}{
+		{smooth: []uint{2}, n: 17}, // 1K to 64M
+		{smooth: []uint{2, 3}},
+		{smooth: []uint{7}},
+		{smooth: nil},
+	} {
+		table := Table(test.smooth)
+		if test.n > 0 && len(table) != test.n {
+			t.Errorf("%v: %d lengths, want %d", test.smooth, len(table), test.n)
+		}
+		if !sort.IntsAreSorted(table) || table[0] < MinLength || table[len(table)-1] > MaxLength {
+			t.Errorf("%v: lengths out of order or range: %d..%d", test.smooth, table[0], table[len(table)-1])
+		}
+		for _, n := range table {
+			s := Smooth(n)
+			allowed := len(test.smooth) == 0 && s > 0
+			for _, want := range test.smooth {
+				allowed = allowed || s == int(want)
+			}
+			if !allowed || n%MinLength != 0 {
+				t.Errorf("%v: length %d is %d-smooth", test.smooth, n, s)
+			}
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	pow2 := []uint{2}
+	for _, test := range []struct {
+		exponent uint64
+		smooth   []uint
+		want     int
+	}{
+		{exponent: 1, smooth: pow2, want: MinLength},
+		{exponent: MaxExponent(4 << 20), smooth: pow2, want: 4 << 20},
+		{exponent: MaxExponent(4<<20) + 1, smooth: pow2, want: 8 << 20},
+		{exponent: MaxExponent(4<<20) + 1, smooth: []uint{2, 5}, want: 4320 << 10},
+		{exponent: MaxExponent(MaxLength), smooth: pow2, want: MaxLength},
+	} {
+		got, err := Select(test.exponent, test.smooth)
+		if err != nil || got != test.want {
+			t.Errorf("Select(%d, %v) = %d, %v, want %d", test.exponent, test.smooth, got, err, test.want)
+		}
+		if !Fits(test.exponent, got) {
+			t.Errorf("M%d doesn't fit %d", test.exponent, got)
+		}
+	}
+
+	// Nothing fits
+	for _, smooth := range [][]uint{pow2, nil} {
+		if n, err := Select(MaxExponent(MaxLength)+1, smooth); err == nil {
+			t.Errorf("%v: Select past the largest length = %d, want an error", smooth, n)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	for _, test := range []struct {
+		n    int
+		want string
+	}{
+		{4 << 20, "4096K"}, {5 << 10, "5K"}, {1000, "1000"},
+	} {
+		if got := Format(test.n); got != test.want {
+			t.Errorf("Format(%d) = %q, want %q", test.n, got, test.want)
+		}
+	}
+}
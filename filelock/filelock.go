@@ -0,0 +1,268 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+// Package filelock implements the <file>.lck locking convention used
+// to share worktodo.txt and results.txt with mfakto and clLucas.
+//
+// Like mfakto and mfaktc, a lock is taken by creating <file>.lck
+// exclusively and released by removing it; the programs only check that
+// the file exists.  The locks taken here also record the owner's PID,
+// hostname and the time, so a lock left behind by a process that died can
+// be recognized and reclaimed.
+package filelock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrLocked is returned when a lock could not be acquired within the retry limit.
+var ErrLocked = errors.New("filelock: file is locked")
+
+var (
+	// Retries is the number of attempts made to acquire each lock.
+	Retries = 5
+	// RetryDelay is the pause between lock attempts.
+	RetryDelay = 5 * time.Second
+	// StaleAge is how old a lock must be to be reclaimed when its owner
+	// can't be checked: empty locks left by mfakto or clLucas, and locks
+	// taken on another host.
+	StaleAge = 10 * time.Minute
+	// Advisory also takes an OS advisory lock (flock) on each .lck file,
+	// where the platform supports it, so a lock is known to be stale as
+	// soon as its owner exits.
+	Advisory = false
+)
+
+var (
+	hostname = host()
+
+	mu   sync.Mutex
+	held = make(map[string]holder) // locks taken by this process
+
+	// writeOwner writes the owner record to a new lock file; a test hook.
+	writeOwner = func(f *os.File, rec string) error {
+		_, err := f.WriteString(rec)
+		return err
+	}
+)
+
+type holder struct {
+	owner string
+	f     *os.File // open while holding the advisory lock
+}
+
+// Lock creates a .lck file for each of fnames.  If any lock cannot be
+// acquired, all locks taken so far are released and ErrLocked is returned.
+func Lock(fnames ...string) error {
+	return LockContext(context.Background(), fnames...)
+}
+
+// LockContext is Lock, giving up early when ctx is cancelled.  A lock file
+// that can't be created for any reason other than already existing fails
+// at once.
+func LockContext(ctx context.Context, fnames ...string) error {
+	for j, fname := range fnames {
+		if err := lock(ctx, fname); err != nil {
+			// Failure path, unlock all locked files before returning
+			Unlock(fnames[:j]...)
+			return err
+		}
+	}
+	return nil
+}
+
+func lock(ctx context.Context, fname string) error {
+	lck := fname + ".lck"
+	reclaimed := false
+	// retry loop in case the file is locked
+	for i := 0; i < Retries; i++ {
+		f, err := os.OpenFile(lck, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0660)
+		if err == nil {
+			return take(fname, f)
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+		if !reclaimed && reclaim(fname) {
+			reclaimed = true
+			i--
+			continue
+		}
+		if i == Retries-1 {
+			break
+		}
+		t := time.NewTimer(RetryDelay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	if data, err := ioutil.ReadFile(lck); err == nil {
+		if o, ok := parseOwner(data); ok {
+			return fmt.Errorf("%w: %s held by process %d on %s since %s", ErrLocked, fname, o.pid, o.host, o.time.Format(time.RFC3339))
+		}
+	}
+	return ErrLocked
+}
+
+// take writes the owner record to the new lock file f and notes the lock
+// as held.  The lock is noted first, so another goroutine that finds the
+// record never takes it for one left by an earlier run with the same PID.
+func take(fname string, f *os.File) error {
+	o := owner{pid: os.Getpid(), host: hostname, time: time.Now().UTC()}
+	if Advisory {
+		o.flock = tryFlock(f) == nil
+	}
+	rec := o.String() + "\n"
+	mu.Lock()
+	held[fname] = holder{owner: rec}
+	mu.Unlock()
+	err := writeOwner(f, rec)
+	if !o.flock || err != nil {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		f = nil
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if err != nil {
+		delete(held, fname)
+		os.Remove(fname + ".lck")
+		return err
+	}
+	held[fname] = holder{owner: rec, f: f}
+	return nil
+}
+
+// reclaim removes the lock on fname if its owner is gone, reporting
+// whether it did.
+func reclaim(fname string) bool {
+	lck := fname + ".lck"
+	data, err := ioutil.ReadFile(lck)
+	if err != nil {
+		return os.IsNotExist(err) // released meanwhile, try again
+	}
+	fi, err := os.Stat(lck)
+	if err != nil || !stale(fname, data, fi.ModTime()) {
+		return false
+	}
+	// Don't remove a lock someone else just took in its place
+	if now, err := ioutil.ReadFile(lck); err != nil || !bytes.Equal(now, data) {
+		return false
+	}
+	return os.Remove(lck) == nil
+}
+
+// stale reports whether the lock on fname, with contents data, was left
+// by a process that no longer holds it.
+func stale(fname string, data []byte, mod time.Time) bool {
+	o, ok := parseOwner(data)
+	if !ok {
+		// mfakto, clLucas and older managers leave empty lock files
+		return time.Since(mod) > StaleAge
+	}
+	if o.flock {
+		if locked, err := flocked(fname + ".lck"); err == nil {
+			return !locked
+		}
+	}
+	switch {
+	case o.host != hostname:
+		return time.Since(o.time) > StaleAge
+	case o.pid == os.Getpid():
+		mu.Lock()
+		h, ok := held[fname]
+		mu.Unlock()
+		// Left by an earlier run that had the same PID
+		return !ok || h.owner != string(data)
+	default:
+		return !alive(o.pid)
+	}
+}
+
+// Unlock removes the .lck file for each of fnames.  Locks this process
+// doesn't hold are left alone.
+func Unlock(fnames ...string) {
+	for _, fname := range fnames {
+		mu.Lock()
+		h, ok := held[fname]
+		delete(held, fname)
+		mu.Unlock()
+		if !ok {
+			continue
+		}
+		unlock(fname, h)
+	}
+}
+
+func unlock(fname string, h holder) {
+	lck := fname + ".lck"
+	if h.f != nil {
+		defer h.f.Close()
+	}
+	// retry loop for safety
+	for i := 0; i < Retries; i++ {
+		data, err := ioutil.ReadFile(lck)
+		if os.IsNotExist(err) || err == nil && string(data) != h.owner {
+			return // reclaimed by someone else
+		}
+		err = os.Remove(lck)
+		if err == nil || os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(RetryDelay)
+	}
+}
+
+// owner is the record written to a lock file.
+type owner struct {
+	pid   int
+	host  string
+	time  time.Time
+	flock bool
+}
+
+func (o owner) String() string {
+	s := fmt.Sprintf("%d %s %s", o.pid, o.host, o.time.Format(time.RFC3339Nano))
+	if o.flock {
+		s += " flock"
+	}
+	return s
+}
+
+func parseOwner(data []byte) (o owner, ok bool) {
+	f := strings.Fields(string(data))
+	if len(f) < 3 {
+		return o, false
+	}
+	pid, err := strconv.Atoi(f[0])
+	if err != nil {
+		return o, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, f[2])
+	if err != nil {
+		return o, false
+	}
+	return owner{pid: pid, host: f[1], time: t, flock: len(f) > 3 && f[3] == "flock"}, true
+}
+
+func host() string {
+	h, err := os.Hostname()
+	if err != nil || h == "" {
+		return "localhost"
+	}
+	return strings.Join(strings.Fields(h), "_")
+}
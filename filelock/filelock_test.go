@@ -0,0 +1,185 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package filelock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func init() {
+	Retries = 2
+	RetryDelay = 10 * time.Millisecond
+}
+
+func TestLockUnlock(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "worktodo.txt"), filepath.Join(dir, "results.txt")
+	if err := Lock(a, b); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(a + ".lck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, ok := parseOwner(data)
+	if !ok || o.pid != os.Getpid() || o.host != hostname {
+		t.Errorf("lock owner = %q", data)
+	}
+	// Held by this process, so not reclaimed
+	if err := Lock(a); !errors.Is(err, ErrLocked) {
+		t.Errorf("second Lock: err = %v, want ErrLocked", err)
+	}
+	Unlock(a, b)
+	for _, f := range []string{a, b} {
+		if _, err := os.Stat(f + ".lck"); !os.IsNotExist(err) {
+			t.Errorf("%s.lck left after Unlock: %v", f, err)
+		}
+	}
+}
+
+func TestLockReleasesOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	writeLock(t, b, fmt.Sprintf("%d %s %s\n", os.Getppid(), hostname, time.Now().UTC().Format(time.RFC3339Nano)))
+	if err := Lock(a, b); !errors.Is(err, ErrLocked) {
+		t.Fatalf("err = %v, want ErrLocked", err)
+	}
+	if _, err := os.Stat(a + ".lck"); !os.IsNotExist(err) {
+		t.Error("first lock kept after the second failed")
+	}
+}
+
+func TestUnlockLeavesOthers(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "worktodo.txt")
+	writeLock(t, f, "")
+	Unlock(f)
+	if _, err := os.Stat(f + ".lck"); err != nil {
+		t.Errorf("Unlock removed a lock it didn't hold: %v", err)
+	}
+}
+
+func TestReclaim(t *testing.T) {
+	old := time.Now().Add(-2 * StaleAge)
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	for _, test := range []struct {
+		name   string
+		data   string
+		mod    time.Time
+		locked bool
+	}{
+		{name: "empty recent", data: "", mod: time.Now(), locked: true},
+		{name: "empty old", data: "", mod: old},
+		{name: "live process", data: fmt.Sprintf("%d %s %s\n", os.Getppid(), hostname, now), mod: time.Now(), locked: true},
+		{name: "dead process", data: fmt.Sprintf("%d %s %s\n", deadPID(t), hostname, now), mod: time.Now()},
+		{name: "earlier run with our PID", data: fmt.Sprintf("%d %s %s\n", os.Getpid(), hostname, now), mod: time.Now()},
+		{name: "other host recent", data: fmt.Sprintf("1 elsewhere %s\n", now), mod: time.Now(), locked: true},
+		{name: "other host old", data: fmt.Sprintf("1 elsewhere %s\n", old.UTC().Format(time.RFC3339Nano)), mod: old},
+	} {
+		f := filepath.Join(t.TempDir(), "worktodo.txt")
+		writeLock(t, f, test.data)
+		if err := os.Chtimes(f+".lck", test.mod, test.mod); err != nil {
+			t.Fatal(err)
+		}
+		err := Lock(f)
+		switch {
+		case test.locked && !errors.Is(err, ErrLocked):
+			t.Errorf("%s: err = %v, want ErrLocked", test.name, err)
+		case !test.locked && err != nil:
+			t.Errorf("%s: lock not reclaimed: %v", test.name, err)
+		}
+		Unlock(f)
+	}
+}
+
+func TestReclaimWhileTaking(t *testing.T) {
+	defer func(w func(*os.File, string) error) { writeOwner = w }(writeOwner)
+	f := filepath.Join(t.TempDir(), "worktodo.txt")
+	// Another goroutine finds the lock just as its record is written
+	writeOwner = func(lck *os.File, rec string) error {
+		if _, err := lck.WriteString(rec); err != nil {
+			return err
+		}
+		if reclaim(f) {
+			t.Error("lock being taken reclaimed as stale")
+		}
+		return nil
+	}
+	if err := Lock(f); err != nil {
+		t.Fatal(err)
+	}
+	defer Unlock(f)
+	if _, err := os.Stat(f + ".lck"); err != nil {
+		t.Errorf("lock file gone: %v", err)
+	}
+}
+
+func TestAdvisory(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("no flock")
+	}
+	Advisory = true
+	defer func() { Advisory = false }()
+	f := filepath.Join(t.TempDir(), "worktodo.txt")
+	if err := Lock(f); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(f + ".lck")
+	if o, _ := parseOwner(data); !o.flock {
+		t.Fatalf("lock record %q has no flock marker", data)
+	}
+	if stale(f, data, time.Now()) {
+		t.Error("held advisory lock reported stale")
+	}
+	mu.Lock()
+	h := held[f]
+	delete(held, f)
+	mu.Unlock()
+	h.f.Close() // as if the process exited without unlocking
+	if !stale(f, data, time.Now()) {
+		t.Error("released advisory lock not reported stale")
+	}
+}
+
+func TestLockContext(t *testing.T) {
+	Retries, RetryDelay = 5, time.Hour
+	defer func() { Retries, RetryDelay = 2, 10*time.Millisecond }()
+	f := filepath.Join(t.TempDir(), "worktodo.txt")
+	writeLock(t, f, "")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := LockContext(ctx, f); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func writeLock(t *testing.T, fname, data string) {
+	t.Helper()
+	if err := ioutil.WriteFile(fname+".lck", []byte(data), 0660); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// deadPID returns the PID of a process that has exited.
+func deadPID(t *testing.T) int {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(exe, "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
@@ -0,0 +1,18 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+
+package filelock
+
+import (
+	"errors"
+	"os"
+)
+
+var errNoFlock = errors.New("filelock: advisory locks not supported")
+
+func tryFlock(f *os.File) error { return errNoFlock }
+
+func flocked(path string) (bool, error) { return false, errNoFlock }
@@ -0,0 +1,11 @@
+module github.com/Kunde21/MersenneManager
+
+go 1.25.0
+
+require (
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/crypto v0.54.0
+	golang.org/x/sys v0.47.0
+	golang.org/x/term v0.45.0
+	gopkg.in/yaml.v2 v2.4.0
+)
@@ -0,0 +1,111 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+// Package gpu72 is a client for the GPU to 72 trial factoring assignment pages.
+package gpu72
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"time"
+
+	"github.com/Kunde21/MersenneManager/worktodo"
+)
+
+// DefaultURL is the GPU72 server address.
+const DefaultURL = "https://www.gpu72.com/"
+
+// Client holds GPU72 account credentials.
+type Client struct {
+	BaseURL    *url.URL
+	User, Pass string
+	HTTP       *http.Client
+}
+
+// New returns a client for the default GPU72 server.
+func New(user, pass string) *Client {
+	base, _ := url.Parse(DefaultURL)
+	jar, _ := cookiejar.New(nil) // cookiejar.New() doesn't have an error return path
+	return &Client{
+		BaseURL: base,
+		User:    user,
+		Pass:    pass,
+		HTTP:    &http.Client{Jar: jar, Timeout: 30 * time.Second},
+	}
+}
+
+// GetWork requests n trial factoring assignments of workType (lltf or dctf),
+// pledging to factor each to at least the target bit level.
+func (c *Client) GetWork(ctx context.Context, n uint, workType string, target uint) ([]worktodo.Entry, error) {
+	asgnURL, err := c.BaseURL.Parse(fmt.Sprintf("/account/getassignments/%s/", workType))
+	if err != nil {
+		return nil, err
+	}
+	reqV := asgnURL.Query()
+	reqV.Set("Number", fmt.Sprint(n))
+	reqV.Set("GHzDays", "")
+	reqV.Set("Low", "")
+	reqV.Set("High", "")
+	reqV.Set("Pledge", fmt.Sprint(target))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, asgnURL.String(), bytes.NewBufferString(reqV.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.User, c.Pass)
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("gpu72 getwork: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("gpu72 getwork response: %w", err)
+	}
+	// GPU72 gives each assignment twice in the page source,
+	// Scan filters it down to unique assignments
+	return worktodo.Scan(body, worktodo.Factor), nil
+}
+
+// Assignments lists the account's outstanding assignments from the view
+// assignments page.
+func (c *Client) Assignments(ctx context.Context) ([]worktodo.Entry, error) {
+	asgnURL, err := c.BaseURL.Parse("/account/assignments/")
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, asgnURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.User, c.Pass)
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("gpu72 assignments: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("gpu72 assignments response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("gpu72 assignments: %s", resp.Status)
+	}
+	if ents := worktodo.Scan(body, worktodo.Factor); len(ents) > 0 {
+		return ents, nil
+	}
+	ents := worktodo.ScanRows(body)
+	for i := range ents {
+		ents[i].Kind = worktodo.Factor // GPU72 only hands out trial factoring
+	}
+	return ents, nil
+}
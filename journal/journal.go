@@ -0,0 +1,189 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+// Package journal is a write-ahead log of result submissions.
+//
+// Each result line is identified by a hash of its text.  Its state is
+// appended to the journal, and synced to disk, before and after every
+// submission, so a manager restarted after a crash knows which results
+// were already sent and never submits one twice.
+package journal
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// State is the submission state of a result.
+type State string
+
+// Submission states.  Acked and Rejected are final.
+const (
+	None     State = ""         // not in the journal
+	Pending  State = "pending"  // waiting to be submitted
+	InFlight State = "inflight" // submission started, outcome unknown
+	Acked    State = "acked"    // accepted by the server
+	Rejected State = "rejected" // refused by the server
+)
+
+// Final reports whether s is a terminal state.
+func (s State) Final() bool {
+	return s == Acked || s == Rejected
+}
+
+// Record is a journal line.
+type Record struct {
+	ID       string    `json:"id"`
+	State    State     `json:"state"`
+	Exponent uint64    `json:"exponent,omitempty"`
+	Line     string    `json:"line,omitempty"`
+	Note     string    `json:"note,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// Journal is an open journal file.
+type Journal struct {
+	path string
+	f    *os.File
+	recs map[string]Record // latest record of each ID
+}
+
+// ID returns the identity of a result line.
+func ID(line string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(line)))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Open replays the journal at path, creating it if needed.
+// A torn final line, left by a crash mid-write, is ignored.
+func Open(path string) (*Journal, error) {
+	j := &Journal{path: path, recs: make(map[string]Record)}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0664)
+	if err != nil {
+		return nil, err
+	}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 4*1024*1024)
+	torn := false
+	for sc.Scan() {
+		var r Record
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil || r.ID == "" {
+			torn = true
+			continue
+		}
+		torn = false
+		j.recs[r.ID] = r
+	}
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("journal %s: %w", path, err)
+	}
+	// End a torn line so the next record starts on a line of its own
+	if torn {
+		if _, err := f.Write([]byte("\n")); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("journal %s: %w", path, err)
+		}
+	}
+	j.f = f
+	return j, nil
+}
+
+// State returns the latest state of the result line with the given ID.
+func (j *Journal) State(id string) State {
+	return j.recs[id].State
+}
+
+// Get returns the latest record for id.
+func (j *Journal) Get(id string) (Record, bool) {
+	r, ok := j.recs[id]
+	return r, ok
+}
+
+// Entry identifies a result line being journaled.
+type Entry struct {
+	Exponent uint64
+	Line     string
+}
+
+// Mark appends a record in state s for each line and syncs the journal.
+func (j *Journal) Mark(s State, note string, lines ...Entry) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	var buf []byte
+	for _, l := range lines {
+		r := Record{ID: ID(l.Line), State: s, Exponent: l.Exponent, Line: l.Line, Note: note, Time: now}
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, b...), '\n')
+		j.recs[r.ID] = r
+	}
+	if _, err := j.f.Write(buf); err != nil {
+		return fmt.Errorf("journal %s: %w", j.path, err)
+	}
+	return j.f.Sync()
+}
+
+// Compact rewrites the journal keeping only the records for which keep
+// returns true.  The new journal replaces the old one atomically.  The
+// journal is left alone when every record is kept.
+func (j *Journal) Compact(keep func(Record) bool) error {
+	drop := false
+	for _, r := range j.recs {
+		drop = drop || !keep(r)
+	}
+	if !drop {
+		return nil
+	}
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0664)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	kept := make(map[string]Record)
+	for id, r := range j.recs {
+		if !keep(r) {
+			continue
+		}
+		b, err := json.Marshal(r)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(append(b, '\n'))
+		kept[id] = r
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+	if err := os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+	j.f.Close()
+	j.recs = kept
+	j.f, err = os.OpenFile(j.path, os.O_RDWR|os.O_APPEND, 0664)
+	return err
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	return j.f.Close()
+}
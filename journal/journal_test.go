@@ -0,0 +1,119 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package journal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	first  = Entry{Exponent: 332197331, Line: "no factor for M332197331 from 2^74 to 2^75 [mfakto 0.15]"}
+	second = Entry{Exponent: 332197369, Line: "no factor for M332197369 from 2^74 to 2^75 [mfakto 0.15]"}
+	third  = Entry{Exponent: 332197391, Line: "no factor for M332197391 from 2^74 to 2^75 [mfakto 0.15]"}
+)
+
+func open(t *testing.T, path string) *Journal {
+	t.Helper()
+	j, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { j.Close() })
+	return j
+}
+
+func mark(t *testing.T, j *Journal, s State, lines ...Entry) {
+	t.Helper()
+	if err := j.Mark(s, "", lines...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func states(j *Journal) []State {
+	return []State{j.State(ID(first.Line)), j.State(ID(second.Line)), j.State(ID(third.Line))}
+}
+
+func TestReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.journal")
+	j := open(t, path)
+	mark(t, j, Pending, first, second)
+	mark(t, j, InFlight, first)
+	mark(t, j, Acked, first)
+	mark(t, j, InFlight, second)
+	j.Close()
+
+	j = open(t, path)
+	want := []State{Acked, InFlight, None}
+	for i, s := range states(j) {
+		if s != want[i] {
+			t.Errorf("line %d: state %q, want %q", i, s, want[i])
+		}
+	}
+	if r, ok := j.Get(ID(first.Line)); !ok || r.Exponent != first.Exponent || r.Line != first.Line {
+		t.Errorf("record = %+v", r)
+	}
+	if ID(first.Line+"\r\n") != ID(first.Line) {
+		t.Error("line ending changes the ID")
+	}
+}
+
+func TestTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.journal")
+	j := open(t, path)
+	mark(t, j, Pending, first)
+	j.Close()
+	// A crash part way through the next record
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.WriteString(`{"id":"` + ID(second.Line) + `","state":"infl`)
+	f.Close()
+
+	j = open(t, path)
+	if got := states(j); got[0] != Pending || got[1] != None {
+		t.Fatalf("after the torn line: %q", got)
+	}
+	mark(t, j, Acked, third)
+	j.Close()
+
+	// Records written after the torn line are kept
+	j = open(t, path)
+	if got := states(j); got[0] != Pending || got[1] != None || got[2] != Acked {
+		t.Errorf("after reopening: %q", got)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.journal")
+	j := open(t, path)
+	mark(t, j, Pending, first, second, third)
+	mark(t, j, Acked, first)
+	if err := j.Compact(func(r Record) bool { return r.ID != ID(second.Line) }); err != nil {
+		t.Fatal(err)
+	}
+	if got := states(j); got[0] != Acked || got[1] != None || got[2] != Pending {
+		t.Errorf("after Compact: %q", got)
+	}
+	if tmp, _ := filepath.Glob(path + ".tmp*"); len(tmp) > 0 {
+		t.Errorf("temporary files left: %v", tmp)
+	}
+	// Still writable, and the dropped record stays gone
+	mark(t, j, Rejected, third)
+	j.Close()
+	j = open(t, path)
+	if got := states(j); got[0] != Acked || got[1] != None || got[2] != Rejected {
+		t.Errorf("after reopening: %q", got)
+	}
+
+	// Nothing dropped
+	before, _ := ioutil.ReadFile(path)
+	if err := j.Compact(func(Record) bool { return true }); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := ioutil.ReadFile(path); string(after) != string(before) {
+		t.Errorf("journal rewritten keeping every record:\n%s\nwas\n%s", after, before)
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+// Package ledger is an on-disk history of the assignments fetched and the
+// results submitted for every device, kept in a bbolt database.
+//
+// The write methods do nothing on a nil *Ledger, so callers can run with
+// the ledger disabled.
+package ledger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/Kunde21/MersenneManager/results"
+)
+
+var (
+	assignBucket = []byte("assignments")
+	resultBucket = []byte("results")
+)
+
+// OpenTimeout is how long Open waits for another process to close the
+// database.
+var OpenTimeout = 10 * time.Second
+
+// Outcomes of a submitted result.
+const (
+	Accepted = "accepted"
+	Refused  = "refused"
+)
+
+// Assignment is the history of an assignment fetched for a device.
+type Assignment struct {
+	Dir      string    `json:"dir"` // device work directory
+	Exponent uint64    `json:"exponent"`
+	AID      string    `json:"aid,omitempty"`
+	Kind     string    `json:"kind"` // worktodo work type
+	Source   string    `json:"source"`
+	BitLo    uint      `json:"bitlo,omitempty"`
+	BitHi    uint      `json:"bithi,omitempty"`
+	Fetched  time.Time `json:"fetched"`
+	Finished time.Time `json:"finished,omitempty"` // result submitted
+	Released time.Time `json:"released,omitempty"` // unreserved
+}
+
+// Open reports whether the assignment was neither finished nor released.
+func (a *Assignment) Open() bool {
+	return a.Finished.IsZero() && a.Released.IsZero()
+}
+
+// Result is the history of a result line found in a device's results.txt.
+type Result struct {
+	Dir       string    `json:"dir"`
+	Exponent  uint64    `json:"exponent"`
+	Line      string    `json:"line"`
+	Seen      time.Time `json:"seen"`
+	Submitted time.Time `json:"submitted,omitempty"`
+	Outcome   string    `json:"outcome,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Credit    float64   `json:"credit,omitempty"` // GHz-days
+}
+
+// Ledger is an open ledger database.
+type Ledger struct {
+	db *bolt.DB
+}
+
+// Open opens the ledger at path, creating it if needed.  A read-only
+// ledger can be opened while another process has it open for writing.
+func Open(path string, readOnly bool) (*Ledger, error) {
+	db, err := bolt.Open(path, 0664, &bolt.Options{Timeout: OpenTimeout, ReadOnly: readOnly})
+	if err != nil {
+		return nil, err
+	}
+	if !readOnly {
+		err = db.Update(func(tx *bolt.Tx) error {
+			if _, err := tx.CreateBucketIfNotExists(assignBucket); err != nil {
+				return err
+			}
+			_, err := tx.CreateBucketIfNotExists(resultBucket)
+			return err
+		})
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &Ledger{db: db}, nil
+}
+
+// Close closes the database.
+func (l *Ledger) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.db.Close()
+}
+
+// assignPrefix is the key prefix of the assignments of exponent in dir.
+func assignPrefix(dir string, exponent uint64) []byte {
+	return binary.BigEndian.AppendUint64(append([]byte(dir), 0), exponent)
+}
+
+// assignKey sorts assignments by device, exponent and fetch time.
+func assignKey(dir string, exponent uint64, fetched time.Time) []byte {
+	return binary.BigEndian.AppendUint64(assignPrefix(dir, exponent), uint64(fetched.UnixNano()))
+}
+
+func resultKey(dir, line string) []byte {
+	return append(append([]byte(dir), 0), line...)
+}
+
+// Fetched records new assignments.
+func (l *Ledger) Fetched(as ...Assignment) error {
+	if l == nil {
+		return nil
+	}
+	return l.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(assignBucket)
+		for _, a := range as {
+			v, err := json.Marshal(a)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(assignKey(a.Dir, a.Exponent, a.Fetched), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Finish marks the newest open assignment of exponent in dir as finished
+// at the given time, or as released if it was unreserved.
+func (l *Ledger) Finish(dir string, exponent uint64, at time.Time, released bool) error {
+	if l == nil {
+		return nil
+	}
+	return l.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(assignBucket)
+		prefix := assignPrefix(dir, exponent)
+		c := b.Cursor()
+		var key []byte
+		var a Assignment
+		for k, v := c.Seek(prefix); bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var cur Assignment
+			if err := json.Unmarshal(v, &cur); err != nil {
+				return err
+			}
+			if cur.Open() {
+				key, a = k, cur
+			}
+		}
+		if key == nil {
+			return nil
+		}
+		if released {
+			a.Released = at
+		} else {
+			a.Finished = at
+		}
+		v, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, v)
+	})
+}
+
+// Seen records result lines found in dir's results file.  Lines already
+// in the ledger are left alone.
+func (l *Ledger) Seen(dir string, res []results.Result, at time.Time) error {
+	if l == nil || len(res) == 0 {
+		return nil
+	}
+	return l.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(resultBucket)
+		for _, r := range res {
+			k := resultKey(dir, r.Line)
+			if b.Get(k) != nil {
+				continue
+			}
+			v, err := json.Marshal(Result{Dir: dir, Exponent: r.Exponent, Line: r.Line, Seen: at})
+			if err != nil {
+				return err
+			}
+			if err := b.Put(k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Submitted records the server's answer to a result.
+func (l *Ledger) Submitted(dir string, r results.Result, outcome, reason string, credit float64, at time.Time) error {
+	if l == nil {
+		return nil
+	}
+	return l.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(resultBucket)
+		k := resultKey(dir, r.Line)
+		rec := Result{Dir: dir, Exponent: r.Exponent, Line: r.Line, Seen: at}
+		if v := b.Get(k); v != nil {
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+		}
+		rec.Submitted, rec.Outcome, rec.Reason, rec.Credit = at, outcome, reason, credit
+		v, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		return b.Put(k, v)
+	})
+}
+
+// Assignments returns the assignments for which keep returns true.
+func (l *Ledger) Assignments(keep func(*Assignment) bool) ([]Assignment, error) {
+	var as []Assignment
+	err := l.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(assignBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, v []byte) error {
+			var a Assignment
+			if err := json.Unmarshal(v, &a); err != nil {
+				return err
+			}
+			if keep(&a) {
+				as = append(as, a)
+			}
+			return nil
+		})
+	})
+	return as, err
+}
+
+// Results returns the results for which keep returns true.
+func (l *Ledger) Results(keep func(*Result) bool) ([]Result, error) {
+	var rs []Result
+	err := l.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(resultBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, v []byte) error {
+			var r Result
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			if keep(&r) {
+				rs = append(rs, r)
+			}
+			return nil
+		})
+	})
+	return rs, err
+}
@@ -0,0 +1,134 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package ledger
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Kunde21/MersenneManager/results"
+)
+
+var day = time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+
+func openLedger(t *testing.T, path string, readOnly bool) *Ledger {
+	t.Helper()
+	l, err := Open(path, readOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func result(t *testing.T, line string) results.Result {
+	t.Helper()
+	r, err := results.Parse(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestAssignments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.db")
+	l := openLedger(t, path, false)
+	const a, b = 332197331, 332197369
+	first := Assignment{Dir: "gpu0", Exponent: a, AID: "A1", Kind: "Factor", Source: "gpu72", BitLo: 74, BitHi: 75, Fetched: day}
+	again := first
+	again.AID, again.Fetched = "A2", day.AddDate(0, 0, 1)
+	other := Assignment{Dir: "gpu1", Exponent: a, Kind: "Factor", Source: "primenet", Fetched: day}
+	released := Assignment{Dir: "gpu0", Exponent: b, Kind: "Factor", Source: "primenet", Fetched: day}
+	if err := l.Fetched(first, again, other, released); err != nil {
+		t.Fatal(err)
+	}
+
+	// The newest open assignment is finished first
+	for i := 0; i < 3; i++ {
+		if err := l.Finish("gpu0", a, day.AddDate(0, 0, 2+i), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Finish("gpu0", b, day.AddDate(0, 0, 5), true); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Finish("gpu2", b, day, false); err != nil {
+		t.Errorf("finishing an unknown assignment: %v", err)
+	}
+	l.Close()
+
+	l = openLedger(t, path, true)
+	got, err := l.Assignments(func(*Assignment) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	again.Finished = day.AddDate(0, 0, 2)
+	first.Finished = day.AddDate(0, 0, 3)
+	released.Released = day.AddDate(0, 0, 5)
+	want := []Assignment{first, again, released, other} // in key order
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("assignments:\n%+v\nwant\n%+v", got, want)
+	}
+
+	open, err := l.Assignments(func(a *Assignment) bool { return a.Open() })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(open) != 1 || open[0].Dir != "gpu1" {
+		t.Errorf("open assignments: %+v", open)
+	}
+}
+
+func TestResults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.db")
+	l := openLedger(t, path, false)
+	seen := result(t, "no factor for M332197331 from 2^74 to 2^75 [mfakto 0.15pre6-Win cl_barrett15_82_gs_2]")
+	unseen := result(t, "no factor for M332197369 from 2^74 to 2^75 [mfakto 0.15pre6-Win cl_barrett15_82_gs_2]")
+	if err := l.Seen("gpu0", []results.Result{seen}, day); err != nil {
+		t.Fatal(err)
+	}
+	// Seeing a line again keeps the first date
+	if err := l.Seen("gpu0", []results.Result{seen}, day.AddDate(0, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Submitted("gpu0", seen, Accepted, "", 1.5, day.AddDate(0, 0, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Submitted("gpu0", unseen, Refused, "duplicate", 0, day.AddDate(0, 0, 3)); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	l = openLedger(t, path, true)
+	got, err := l.Results(func(*Result) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Result{
+		{Dir: "gpu0", Exponent: seen.Exponent, Line: seen.Line, Seen: day, Submitted: day.AddDate(0, 0, 2), Outcome: Accepted, Credit: 1.5},
+		{Dir: "gpu0", Exponent: unseen.Exponent, Line: unseen.Line, Seen: day.AddDate(0, 0, 3), Submitted: day.AddDate(0, 0, 3), Outcome: Refused, Reason: "duplicate"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("results:\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestNilLedger(t *testing.T) {
+	var l *Ledger
+	r := results.Result{Line: "line", Exponent: 332197331}
+	for name, err := range map[string]error{
+		"Fetched":   l.Fetched(Assignment{Exponent: 332197331}),
+		"Finish":    l.Finish("gpu0", 332197331, day, false),
+		"Seen":      l.Seen("gpu0", []results.Result{r}, day),
+		"Submitted": l.Submitted("gpu0", r, Accepted, "", 0, day),
+		"Close":     l.Close(),
+	} {
+		if err != nil {
+			t.Errorf("%s on a nil ledger: %v", name, err)
+		}
+	}
+}
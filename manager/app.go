@@ -0,0 +1,274 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/Kunde21/MersenneManager/config"
+)
+
+// App is the command line program shared by TFmanager, LLmanager and
+// MersenneManager.  It reads the settings files, credentials, environment
+// and flags, validates the result, then runs a command or manages the
+// devices, reading everything again on SIGHUP.
+type App struct {
+	SettingsFile string // default -config file, e.g. TFsettings.yml
+	LogPrefix    string
+	KindOf       KindOf
+	GPU72        bool // offer the GPU72 account options
+
+	// Defaults returns the settings used where the files set nothing.
+	Defaults func() config.Settings
+	// Flags, if set, defines the program's own options over s, after
+	// the ones every program has.
+	Flags func(fs *flag.FlagSet, s *config.Settings)
+
+	configFile string
+}
+
+// options are the command line flags that aren't settings.
+type options struct {
+	write, encrypt, force bool
+}
+
+// Main runs the program.  It only returns after a clean shutdown;
+// bad settings and failed commands exit with an error.
+func (a *App) Main() {
+	sett := a.Defaults()
+	a.configFile = config.FlagValue(os.Args[1:], "config", a.SettingsFile)
+	if err := a.load(&sett); err != nil {
+		log.Fatalln("Settings error:", err)
+	}
+	opts := a.flags(flag.CommandLine, &sett)
+	flag.StringVar(&a.configFile, "config", a.configFile, "Settings file, read after the system and user settings files")
+	flag.Parse()
+
+	if opts.write {
+		if err := config.Write(a.configFile, &sett); err != nil {
+			log.Fatalln("Error writing", a.configFile, err)
+		}
+		if err := config.SaveCredentials(&sett, opts.encrypt); err != nil {
+			log.Fatalln("Error writing", sett.CredFile, err)
+		}
+		return
+	}
+	if flag.Arg(0) != "validate" && !(sett.Primenet() || a.GPU72 && sett.GPU72()) {
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	log.SetFlags(log.LstdFlags | log.LUTC)
+	log.SetPrefix(a.LogPrefix)
+	if sett.LogFile != "" {
+		file, err := os.OpenFile(sett.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0664)
+		if err != nil {
+			log.Fatalln("Error opening log file:", err)
+		}
+		log.SetOutput(file)
+	}
+
+	a.check(&sett, opts.force)
+	st, err := NewSetup(&sett, a.KindOf)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if flag.NArg() > 0 {
+		if err := st.Manager.Command(ctx, st.Devices, flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	a.releaseRemoved(ctx, &sett, st)
+	Serve(ctx, st, a.reload)
+}
+
+// check reports the problems in the settings.  The validate command lists
+// them and exits; otherwise they are logged and stop the manager unless
+// force is set.
+func (a *App) check(s *config.Settings, force bool) {
+	problems := Validate(s, a.KindOf)
+	if flag.Arg(0) == "validate" {
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+		fmt.Println("Settings OK")
+		os.Exit(0)
+	}
+	logProblems(problems)
+	if len(problems) > 0 && !force {
+		log.Fatalf("%d problems in the settings: fix them, or run with -force to start anyway", len(problems))
+	}
+}
+
+// logProblems logs the problems found in the settings.
+func logProblems(problems []error) {
+	for _, p := range problems {
+		log.Println("Settings:", p)
+	}
+}
+
+// NewSetup builds the devices and manager for s, with the worker program
+// of each device given by kindOf.
+func NewSetup(s *config.Settings, kindOf KindOf) (*Setup, error) {
+	if !(s.Primenet() || s.GPU72()) {
+		return nil, errors.New("no Primenet or GPU72 account configured")
+	}
+	st := &Setup{Poll: s.Poll()}
+	// Primenet is the only work source for some device
+	needLogin := false
+	for i := range s.Devices {
+		kind, err := kindOf(s.Devices[i])
+		if err != nil {
+			return nil, fmt.Errorf("device %d: %w", i, err)
+		}
+		dev, err := NewDevice(kind, s.Devices[i])
+		if err != nil {
+			return nil, fmt.Errorf("device %d: %w", i, err)
+		}
+		st.Devices = append(st.Devices, dev)
+		needLogin = needLogin || kind == ClLucas
+	}
+	var err error
+	if st.Manager, err = New(s); err != nil {
+		return nil, err
+	}
+	needLogin = needLogin || st.Manager.GPU72 == nil
+	if needLogin && st.Manager.Primenet == nil {
+		return nil, errors.New("Primenet account is required for clLucas devices")
+	}
+	st.Retry.Min, st.Retry.Max = s.Retry()
+	return st, nil
+}
+
+// releaseRemoved unreserves the work of devices dropped from the settings,
+// when enabled.
+func (a *App) releaseRemoved(ctx context.Context, s *config.Settings, st *Setup) {
+	if s.Unreserve {
+		st.Manager.Login(ctx)
+	}
+	if err := st.Manager.ReleaseRemoved(ctx, DevicesFile(a.configFile), st.Devices, s.Unreserve); err != nil {
+		log.Println(err)
+	}
+}
+
+// reload reads the settings files and command line again for SIGHUP.
+func (a *App) reload(ctx context.Context) (*Setup, error) {
+	s := a.Defaults()
+	if err := a.load(&s); err != nil {
+		return nil, err
+	}
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	opts := a.flags(fs, &s)
+	fs.String("config", "", "")
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		return nil, err
+	}
+	problems := Validate(&s, a.KindOf)
+	if len(problems) > 0 && !opts.force {
+		return nil, errors.Join(problems...)
+	}
+	logProblems(problems)
+	st, err := NewSetup(&s, a.KindOf)
+	if err != nil {
+		return nil, err
+	}
+	a.releaseRemoved(ctx, &s, st)
+	return st, nil
+}
+
+// load reads the settings files, from the system-wide one to -config,
+// then the credentials and the environment over s.
+func (a *App) load(s *config.Settings) error {
+	if err := config.LoadFiles(s, config.Layers(a.SettingsFile, a.configFile)...); err != nil {
+		return err
+	}
+	if err := s.LoadCredentials(config.Passphrase); err != nil {
+		return err
+	}
+	if err := s.LoadEnv(); err != nil {
+		return err
+	}
+	if len(s.Devices) == 0 {
+		return errors.New("no devices configured")
+	}
+	return nil
+}
+
+// flags defines the command line options on fs: those that override s,
+// then the program's own, then those that aren't settings.
+func (a *App) flags(fs *flag.FlagSet, s *config.Settings) *options {
+	fs.StringVar(&s.Usrname, "usr", s.Usrname, "Primenet user name")
+	fs.Func("pass", "Primenet password, visible to other users: prefer "+config.EnvPass+" or the credentials file", func(v string) error {
+		s.Pass = v
+		return nil
+	})
+	if a.GPU72 {
+		fs.StringVar(&s.GPU72Usr, "gusr", s.GPU72Usr, "GPU72 user name")
+		fs.Func("gpass", "GPU72 password, visible to other users: prefer "+config.EnvGPU72Pass+" or the credentials file", func(v string) error {
+			s.GPU72Pass = v
+			return nil
+		})
+	}
+	fs.UintVar(&s.Polltime, "time", s.Polltime, "Polling delay in hours, 0 to run once (max 120)")
+	fs.UintVar(&s.RetryMin, "retry", s.RetryMin, "Minutes before retrying a failed update, doubling on each failure (default 2)")
+	fs.UintVar(&s.RetryMax, "maxretry", s.RetryMax, "Longest retry delay in minutes (default 60)")
+	fs.BoolVar(&s.V5, "v5", s.V5, "Use the PrimeNet v5 API, registering each device as a computer")
+	fs.StringVar(&s.PrimenetURL, "primenet", s.PrimenetURL, "Primenet server address (default https://www.mersenne.org/)")
+	if a.GPU72 {
+		fs.StringVar(&s.GPU72URL, "gpu72", s.GPU72URL, "GPU72 server address (default https://www.gpu72.com/)")
+	}
+	fs.StringVar(&s.V5URL, "v5url", s.V5URL, "PrimeNet v5 server address (default https://v5.mersenne.org/v5server/)")
+	fs.StringVar(&s.Proxy, "proxy", s.Proxy, "Proxy URL: http://, https:// or socks5://host:port")
+	fs.StringVar(&s.CABundle, "ca", s.CABundle, "PEM file of extra certificate authorities to trust")
+	fs.UintVar(&s.TimeoutSec, "timeout", s.TimeoutSec, "Request timeout in seconds, 0 for 30")
+	fs.Func("device", "Override a device setting: index:Key=value or name:Key=value, e.g. 1:Assignments=10 (repeatable)", s.SetDevice)
+	fs.StringVar(&s.LogFile, "logs", s.LogFile, "Log file for the manager's output")
+	if a.Flags != nil {
+		a.Flags(fs, s)
+	}
+
+	opts := &options{}
+	fs.BoolVar(&opts.write, "w", false, "Write the settings to the -config file, and passwords to the credentials file, and exit")
+	fs.BoolVar(&opts.encrypt, "encrypt", false, "With -w, encrypt the credentials file with a passphrase")
+	fs.BoolVar(&opts.force, "force", false, "Start even if the settings have problems")
+	return opts
+}
+
+// DevicesFile returns the file beside the settings file config that lists
+// the devices seen on earlier runs: TFsettings.yml keeps them in
+// TFdevices.json, and other names such as gpu1.yml in gpu1.devices.json.
+func DevicesFile(config string) string {
+	dir, name := filepath.Split(config)
+	if strings.HasSuffix(name, "settings.yml") {
+		return filepath.Join(dir, strings.TrimSuffix(name, "settings.yml")+"devices.json")
+	}
+	return filepath.Join(dir, strings.TrimSuffix(name, filepath.Ext(name))+".devices.json")
+}
+
+// DeviceFlags defines the options that set the work directory, device
+// number and cache size of d, for programs configured with one device.
+func DeviceFlags(fs *flag.FlagSet, d *config.Device) {
+	fs.UintVar(&d.Device, "dev", d.Device, "OpenCL device number (default 0)")
+	fs.UintVar(&d.Cache, "n", d.Cache, "Number of assignments to cache")
+	fs.StringVar(&d.Workdir, "dir", d.Workdir, "Work directory with worktodo.txt and results.txt")
+}
@@ -0,0 +1,59 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package manager
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Kunde21/MersenneManager/config"
+)
+
+func TestNewSetup(t *testing.T) {
+	primenet := config.Settings{Usrname: "user", Pass: "pass"}
+	gpu72 := config.Settings{GPU72Usr: "user", GPU72Pass: "pass"}
+	for _, test := range []struct {
+		name  string
+		sett  config.Settings
+		kinds []string
+		ok    bool
+	}{
+		{name: "no account", kinds: []string{"mfakto"}},
+		{name: "mfakto on GPU72", sett: gpu72, kinds: []string{"mfakto"}, ok: true},
+		{name: "mfakto on Primenet", sett: primenet, kinds: []string{"mfakto"}, ok: true},
+		{name: "clLucas on GPU72", sett: gpu72, kinds: []string{"mfakto", "clLucas"}},
+		{name: "clLucas on Primenet", sett: primenet, kinds: []string{"mfakto", "clLucas"}, ok: true},
+		{name: "unknown kind", sett: primenet, kinds: []string{"prime95"}},
+	} {
+		s := test.sett
+		for _, k := range test.kinds {
+			s.Devices = append(s.Devices, config.Device{Kind: k, Workdir: t.TempDir(), WorkType: "101", Cache: 1})
+		}
+		st, err := NewSetup(&s, DeviceKind)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: err = %v", test.name, err)
+			continue
+		}
+		if err == nil && len(st.Devices) != len(test.kinds) {
+			t.Errorf("%s: %d devices, want %d", test.name, len(st.Devices), len(test.kinds))
+		}
+	}
+}
+
+func TestDevicesFile(t *testing.T) {
+	for _, test := range []struct {
+		config, want string
+	}{
+		{"TFsettings.yml", "TFdevices.json"},
+		{"settings.yml", "devices.json"},
+		{filepath.Join("rig", "LLsettings.yml"), filepath.Join("rig", "LLdevices.json")},
+		{filepath.Join("rig", "gpu1.yml"), filepath.Join("rig", "gpu1.devices.json")},
+		{"gpu2", "gpu2.devices.json"},
+	} {
+		if got := DevicesFile(test.config); got != test.want {
+			t.Errorf("DevicesFile(%q) = %q, want %q", test.config, got, test.want)
+		}
+	}
+}
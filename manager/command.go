@@ -0,0 +1,310 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Kunde21/MersenneManager/ledger"
+	"github.com/Kunde21/MersenneManager/state"
+	"github.com/Kunde21/MersenneManager/worktodo"
+)
+
+// Commands lists the subcommands run by Command.
+var Commands = []string{"ledger", "reconcile", "status", "unreserve"}
+
+// Command runs the subcommand named by args[0] against devs.
+func (m *Manager) Command(ctx context.Context, devs []*Device, args []string) error {
+	switch args[0] {
+	case "ledger":
+		return m.ledgerCmd(os.Stdout, devs, args[1:])
+	case "reconcile":
+		return m.reconcileCmd(ctx, os.Stdout, devs, args[1:])
+	case "status":
+		return status(os.Stdout, devs, args[1:])
+	case "unreserve":
+		return m.unreserveCmd(ctx, devs, args[1:])
+	}
+	return fmt.Errorf("unknown command %q, use one of %v", args[0], Commands)
+}
+
+func (m *Manager) unreserveCmd(ctx context.Context, devs []*Device, args []string) error {
+	fs := flag.NewFlagSet("unreserve", flag.ContinueOnError)
+	devN := fs.Int("dev", -1, "Device index in the settings file, -1 for all devices")
+	exp := fs.Uint64("exp", 0, "Exponent to unreserve")
+	excess := fs.Bool("excess", false, "Unreserve assignments beyond each device's Assignments setting")
+	local := fs.Bool("local", false, "Only remove from worktodo.txt, don't contact the servers")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *devN < 0 && *exp == 0 && !*excess {
+		fs.Usage()
+		return errors.New("unreserve: choose -dev, -exp or -excess")
+	}
+	if *devN >= len(devs) {
+		return fmt.Errorf("unreserve: no device %d, %d configured", *devN, len(devs))
+	}
+	if *devN >= 0 {
+		devs = devs[*devN : *devN+1]
+	}
+	if !*local {
+		if err := m.Login(ctx); err != nil {
+			return err
+		}
+	}
+
+	var errs []error
+	total := 0
+	for _, dev := range devs {
+		n, err := m.Unreserve(ctx, dev, *local, func(i int, e *worktodo.Entry) bool {
+			switch {
+			case *exp != 0 && e.Exponent != *exp:
+				return false
+			case *excess:
+				return i >= int(dev.Cache)
+			}
+			return true
+		})
+		total += n
+		errs = append(errs, err)
+	}
+	log.Println("Unreserved", total, "assignments")
+	return errors.Join(errs...)
+}
+
+// status prints the assignments of each device with their due dates and
+// completion estimates, flagging those at risk of expiring.
+func status(w io.Writer, devs []*Device, args []string) error {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	risk := fs.Bool("risk", false, "Only list assignments at risk of expiring")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEV\tEXPONENT\tWORK\tSOURCE\tFETCHED\tDUE\tDONE\tESTIMATE\t")
+	now := time.Now().UTC()
+	var errs []error
+	for i, dev := range devs {
+		data, err := ioutil.ReadFile(dev.Files.Todo)
+		if err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+			continue
+		}
+		st, err := state.Load(dev.Files.Dir)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		ents := worktodo.Parse(data).Entries(dev.workKinds()...)
+		for _, e := range ents {
+			if st.Find(e.Exponent) == nil {
+				st.Add(&state.Assignment{Exponent: e.Exponent, Kind: e.Kind, Fetched: now})
+			}
+		}
+		etas := estimate(st, ents, now)
+		for j, e := range ents {
+			a := st.Find(e.Exponent)
+			flagged := atRisk(a, etas[j], now)
+			if *risk && !flagged {
+				continue
+			}
+			source, note := a.Source, ""
+			if source == "" {
+				source = "-"
+			}
+			if flagged {
+				note = "AT RISK"
+			}
+			fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\t%s\t%.1f%%\t%s\t%s\n", i, e.Exponent, e.Kind, source,
+				a.Fetched.Format("2006-01-02"), dueDate(a).Format("2006-01-02"), a.Percent, fmtETA(etas[j]), note)
+		}
+	}
+	return errors.Join(append(errs, tw.Flush())...)
+}
+
+// ledgerCmd queries the ledger for assignments or results.
+func (m *Manager) ledgerCmd(w io.Writer, devs []*Device, args []string) error {
+	fs := flag.NewFlagSet("ledger", flag.ContinueOnError)
+	devN := fs.Int("dev", -1, "Device index in the settings file, -1 for all devices")
+	exp := fs.Uint64("exp", 0, "Only this exponent")
+	since := fs.String("since", "", "Start date, YYYY-MM-DD, of the assignments finished or unreserved, or the results submitted")
+	until := fs.String("until", "", "End date (exclusive), YYYY-MM-DD")
+	showRes := fs.Bool("results", false, "List results instead of assignments")
+	open := fs.Bool("open", false, "Only assignments that were never finished or unreserved")
+	outcome := fs.String("outcome", "", "Only results with this outcome: accepted or refused")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if m.Ledger == "" {
+		return errors.New("ledger: no Ledger file in the settings")
+	}
+	if *devN >= len(devs) {
+		return fmt.Errorf("ledger: no device %d, %d configured", *devN, len(devs))
+	}
+	from, err := parseDate(*since, time.Time{})
+	if err != nil {
+		return err
+	}
+	to, err := parseDate(*until, time.Now().AddDate(100, 0, 0))
+	if err != nil {
+		return err
+	}
+	index := make(map[string]string)
+	for i, dev := range devs {
+		index[dev.Files.Dir] = fmt.Sprint(i)
+	}
+	name := func(dir string) string {
+		if n, ok := index[dir]; ok {
+			return n
+		}
+		return dir
+	}
+	match := func(dir string, exponent uint64, t time.Time) bool {
+		return (*devN < 0 || dir == devs[*devN].Files.Dir) &&
+			(*exp == 0 || exponent == *exp) &&
+			!t.Before(from) && t.Before(to)
+	}
+
+	led, err := ledger.Open(m.Ledger, true)
+	if err != nil {
+		return fmt.Errorf("ledger: %w", err)
+	}
+	defer led.Close()
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	if *showRes {
+		rs, err := led.Results(func(r *ledger.Result) bool {
+			t := r.Submitted
+			if t.IsZero() {
+				t = r.Seen
+			}
+			return match(r.Dir, r.Exponent, t) && (*outcome == "" || r.Outcome == *outcome)
+		})
+		if err != nil {
+			return err
+		}
+		var credit float64
+		fmt.Fprintln(tw, "DEV\tEXPONENT\tSEEN\tSUBMITTED\tOUTCOME\tCREDIT\tREASON")
+		for _, r := range rs {
+			credit += r.Credit
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%.3f\t%s\n", name(r.Dir), r.Exponent,
+				fmtDate(r.Seen), fmtDate(r.Submitted), r.Outcome, r.Credit, r.Reason)
+		}
+		fmt.Fprintf(tw, "%d results, %.3f GHz-days\n", len(rs), credit)
+		return tw.Flush()
+	}
+
+	// Open assignments have no finish date, so only match without a range
+	as, err := led.Assignments(func(a *ledger.Assignment) bool {
+		t := a.Finished
+		if t.IsZero() {
+			t = a.Released
+		}
+		return match(a.Dir, a.Exponent, t) && (!*open || a.Open())
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(tw, "DEV\tEXPONENT\tWORK\tBITS\tSOURCE\tFETCHED\tFINISHED\tRELEASED")
+	for _, a := range as {
+		bits := "-"
+		if a.BitHi > 0 {
+			bits = fmt.Sprintf("%d-%d", a.BitLo, a.BitHi)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n", name(a.Dir), a.Exponent, a.Kind, bits,
+			a.Source, fmtDate(a.Fetched), fmtDate(a.Finished), fmtDate(a.Released))
+	}
+	fmt.Fprintf(tw, "%d assignments\n", len(as))
+	return tw.Flush()
+}
+
+// parseDate parses a YYYY-MM-DD date in UTC, returning def for "".
+func parseDate(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+func fmtDate(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format("2006-01-02 15:04")
+}
+
+// reconcileCmd reports, and optionally fixes, assignments held only by the
+// servers or only by the devices.
+func (m *Manager) reconcileCmd(ctx context.Context, w io.Writer, devs []*Device, args []string) error {
+	fs := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	unres := fs.Bool("unreserve", false, "Unreserve assignments the server holds but no device has")
+	readd := fs.Bool("readd", false, "Put assignments the server holds back into worktodo.txt")
+	drop := fs.Bool("drop", false, "Remove assignments the server no longer holds from worktodo.txt")
+	all := fs.Bool("all", false, "Also fix server assignments this manager didn't fetch")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *unres && *readd {
+		return errors.New("reconcile: use only one of -unreserve and -readd")
+	}
+	if err := m.Login(ctx); err != nil {
+		return err
+	}
+	rec, err := m.Reconcile(ctx, devs)
+	if err != nil {
+		return err
+	}
+	if len(rec.Empty) > 0 && (*drop || *readd) {
+		return fmt.Errorf("reconcile: the %s assignment list is empty but worktodo.txt holds its assignments, not changing worktodo.txt",
+			strings.Join(rec.Empty, " and "))
+	}
+
+	var errs []error
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "EXPONENT\tWORK\tSOURCE\tDIRECTORY\tPROBLEM\tACTION")
+	for _, o := range rec.Missing {
+		action, err := "-", error(nil)
+		switch {
+		case !o.Known && !*all:
+			action = "not fetched here"
+		case *unres:
+			action, err = "unreserved", m.Release(ctx, o)
+		case *readd:
+			action, err = "re-added", m.Readd(ctx, devs, o)
+		}
+		if err != nil {
+			action, errs = "failed", append(errs, err)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", o.Entry.Exponent, o.Entry.Kind, o.Source, dirOrDash(o.Dir), "not in worktodo.txt", action)
+	}
+	for _, o := range rec.Gone {
+		action, err := "-", error(nil)
+		if *drop {
+			action, err = "removed", m.Drop(ctx, o)
+		}
+		if err != nil {
+			action, errs = "failed", append(errs, err)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", o.Entry.Exponent, o.Entry.Kind, o.Source, o.Dir, "not assigned on server", action)
+	}
+	fmt.Fprintf(tw, "%d missing locally, %d no longer assigned\n", len(rec.Missing), len(rec.Gone))
+	return errors.Join(append(errs, tw.Flush())...)
+}
+
+func dirOrDash(dir string) string {
+	if dir == "" {
+		return "-"
+	}
+	return dir
+}
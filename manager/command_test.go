@@ -0,0 +1,78 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package manager
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kunde21/MersenneManager/ledger"
+)
+
+func TestLedgerCmd(t *testing.T) {
+	const a, b, c, d = 332197331, 332197369, 332197391, 332197411
+	m, dev, _ := newPrimenet(t)
+	m.Ledger = filepath.Join(t.TempDir(), "TFledger.db")
+	date := func(month time.Month, day int) time.Time { return time.Date(2026, month, day, 12, 0, 0, 0, time.UTC) }
+	led, err := ledger.Open(m.Ledger, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := dev.Files.Dir
+	err = led.Fetched(
+		ledger.Assignment{Dir: dir, Exponent: a, Kind: "Factor", Fetched: date(8, 20), Finished: date(9, 5)},
+		ledger.Assignment{Dir: dir, Exponent: b, Kind: "Factor", Fetched: date(9, 2), Finished: date(10, 3)},
+		ledger.Assignment{Dir: dir, Exponent: c, Kind: "Factor", Fetched: date(9, 10)},
+		ledger.Assignment{Dir: dir, Exponent: d, Kind: "Factor", Fetched: date(8, 1), Released: date(9, 15)},
+	)
+	led.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A pass over the device records the results seen and the answers
+	ioutil.WriteFile(dev.Files.Res, []byte(tfResult(a)+"\n"+tfResult(b)+"\n"), 0664)
+	if err := m.SendResults(context.Background(), dev); err != nil {
+		t.Fatal(err)
+	}
+
+	today := time.Now().UTC()
+	for _, test := range []struct {
+		args []string
+		want []uint64
+	}{
+		{args: nil, want: []uint64{a, b, c, d}},
+		{args: []string{"-since", "2026-09-01", "-until", "2026-10-01"}, want: []uint64{a, d}},
+		{args: []string{"-since", "2026-10-01"}, want: []uint64{b}},
+		{args: []string{"-open"}, want: []uint64{c}},
+		{args: []string{"-results", "-outcome", "accepted"}, want: []uint64{a, b}},
+		{args: []string{"-results", "-until", today.Format("2006-01-02")}, want: nil},
+		{args: []string{"-results", "-since", today.Format("2006-01-02")}, want: []uint64{a, b}},
+	} {
+		var out bytes.Buffer
+		if err := m.ledgerCmd(&out, []*Device{dev}, test.args); err != nil {
+			t.Errorf("%q: %v", test.args, err)
+			continue
+		}
+		var got []uint64
+		for _, l := range strings.Split(out.String(), "\n") {
+			var exp uint64
+			if f := strings.Fields(l); len(f) > 1 && f[0] == "0" {
+				if _, err := fmt.Sscan(f[1], &exp); err == nil {
+					got = append(got, exp)
+				}
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%q: exponents %v, want %v\n%s", test.args, got, test.want, out.String())
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package manager
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Kunde21/MersenneManager/config"
+)
+
+// Kind identifies the worker program run on a device.
+type Kind int
+
+// Supported worker programs.
+const (
+	Mfakto Kind = iota
+	ClLucas
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Mfakto:
+		return "mfakto"
+	case ClLucas:
+		return "clLucas"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// ParseKind returns the Kind named by s.  Matching is case-insensitive and
+// accepts the TF/LL work names as aliases.
+func ParseKind(s string) (Kind, error) {
+	switch strings.ToLower(s) {
+	case "mfakto", "tf":
+		return Mfakto, nil
+	case "cllucas", "ll":
+		return ClLucas, nil
+	}
+	return 0, fmt.Errorf("unknown device kind %q: use mfakto or clLucas", s)
+}
+
+// WorkOptions maps the GPU72 work options to their codes.
+var WorkOptions = map[string]uint{
+	"what_makes_sense": 0,
+	"lowest_tf_level":  1,
+	"highest_tf_level": 2,
+	"lowest_exponent":  3,
+	"oldest_exponent":  4,
+	"let_gpu72_decide": 9,
+}
+
+// MinTarget is the lowest "factor to" bit level requested for mfakto work.
+const MinTarget = 73
+
+// Device is a configured device with its work files resolved.
+type Device struct {
+	config.Device
+	Kind     Kind
+	Files    Files
+	GPU72Opt uint // GPU72 work option code
+	Pref     uint // Primenet work preference code
+
+	prog *progress // last progress line from the worker
+}
+
+// Files holds the paths of the files shared with the worker program.
+type Files struct {
+	Dir, Exec, Todo, Res, Sent string
+	Rejected                   string // results the server refused, with the reason
+	Journal                    string // write-ahead log of result submissions
+}
+
+// NewDevice resolves the work directory of cfg and fills in defaults for
+// the settings that kind does not understand.
+func NewDevice(kind Kind, cfg config.Device) (*Device, error) {
+	dir, err := filepath.Abs(cfg.Workdir)
+	if err != nil {
+		return nil, fmt.Errorf("workdir path cannot be resolved: %s", cfg.Workdir)
+	}
+	dev := &Device{Device: cfg, Kind: kind, prog: &progress{}}
+	dev.Files = Files{
+		Dir:      dir,
+		Exec:     execPath(dir, cfg.Exec),
+		Todo:     filepath.Join(dir, "worktodo.txt"),
+		Res:      filepath.Join(dir, "results.txt"),
+		Rejected: filepath.Join(dir, "results_rejected.txt"),
+		Journal:  filepath.Join(dir, "results.journal"),
+	}
+	switch kind {
+	case Mfakto:
+		dev.Files.Sent = filepath.Join(dir, "results_sent.txt")
+		dev.setTF()
+	case ClLucas:
+		dev.Files.Sent = filepath.Join(dir, "result_sent.txt")
+		pref, err := strconv.ParseUint(cfg.WorkType, 10, 0)
+		if err != nil {
+			return nil, fmt.Errorf("worktype code %q: %w", cfg.WorkType, err)
+		}
+		dev.Pref = uint(pref)
+	default:
+		return nil, fmt.Errorf("unknown device kind: %v", kind)
+	}
+	return dev, nil
+}
+
+// setTF fills in the trial factoring settings, replacing the work type,
+// work option and target that Validate reports as bad.
+func (dev *Device) setTF() {
+	dev.Pref = 2 // Trial Factoring is code "2"
+	if dev.WorkType != "dctf" {
+		dev.WorkType = "lltf"
+	}
+
+	dev.GPU72Opt = WorkOptions[dev.WorkOption] // what_makes_sense when unknown
+
+	if dev.Target < MinTarget {
+		dev.Target = MinTarget
+	}
+}
+
+// execPath resolves a relative executable path containing a directory
+// against the work directory.  Bare names are looked up in PATH when run.
+func execPath(dir, exe string) string {
+	if exe == "" || filepath.IsAbs(exe) || filepath.Base(exe) == exe {
+		return exe
+	}
+	return filepath.Join(dir, exe)
+}
@@ -0,0 +1,137 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// health tracks whether the servers are reachable.  While degraded the
+// manager keeps its devices running, spools results in results.txt and
+// only fetches work from the sources that still answer.
+type health struct {
+	sync.Mutex
+	degraded bool
+	since    time.Time
+}
+
+// Degraded reports whether the servers were unreachable at the last attempt.
+func (m *Manager) Degraded() bool {
+	m.health.Lock()
+	defer m.health.Unlock()
+	return m.health.degraded
+}
+
+// degrade enters the degraded state, logging the cause when it starts.
+func (m *Manager) degrade(cause error) {
+	m.health.Lock()
+	defer m.health.Unlock()
+	if m.health.degraded {
+		return
+	}
+	m.health.degraded, m.health.since = true, time.Now()
+	log.Println("DEGRADED: servers unreachable, spooling results and running on cached work:", cause)
+}
+
+// recovered leaves the degraded state.
+func (m *Manager) recovered() {
+	m.health.Lock()
+	defer m.health.Unlock()
+	if !m.health.degraded {
+		return
+	}
+	m.health.degraded = false
+	log.Printf("Servers reachable again after %v, submitting spooled results", time.Since(m.health.since).Round(time.Second))
+}
+
+// loginRetry is how long a failed login is reported to the other devices
+// before trying again.  A variable for tests.
+var loginRetry = time.Minute
+
+// session is the Primenet login shared by the devices.
+type session struct {
+	sync.Mutex
+	at  time.Time
+	err error
+}
+
+// Login signs in to Primenet.  Failing to sign in puts the manager in the
+// degraded state until a later login succeeds.
+func (m *Manager) Login(ctx context.Context) error {
+	m.sess.Lock()
+	defer m.sess.Unlock()
+	return m.signIn(ctx)
+}
+
+// login signs in when there is no Primenet session yet, or while degraded
+// to find out whether the servers are back.  A session that expires is
+// renewed by the client on the next request.  A failed attempt is reused
+// by the other devices for a while.
+func (m *Manager) login(ctx context.Context) error {
+	m.sess.Lock()
+	defer m.sess.Unlock()
+	if m.Primenet == nil {
+		return nil
+	}
+	if m.sess.err != nil && time.Since(m.sess.at) < loginRetry {
+		return m.sess.err
+	}
+	if m.sess.err == nil && m.Primenet.HasSession() && !m.Degraded() {
+		return nil
+	}
+	return m.signIn(ctx)
+}
+
+// signIn does the login for Login and login, with sess locked.
+func (m *Manager) signIn(ctx context.Context) error {
+	if m.Primenet == nil {
+		return nil
+	}
+	m.sess.at = time.Now()
+	m.sess.err = m.Primenet.Login(ctx)
+	if m.sess.err != nil {
+		log.Println(m.sess.err)
+		m.degrade(m.sess.err)
+		return m.sess.err
+	}
+	m.recovered()
+	return nil
+}
+
+// netError reports whether err is a failure to reach a server.
+func netError(err error) bool {
+	var nErr net.Error
+	return errors.As(err, &nErr)
+}
+
+// Backoff is a retry delay that doubles after each failure, from Min up
+// to Max.
+type Backoff struct {
+	Min, Max time.Duration
+	cur      time.Duration
+}
+
+// Next returns the delay before the next attempt.
+func (b *Backoff) Next() time.Duration {
+	switch {
+	case b.cur < b.Min:
+		b.cur = b.Min
+	case b.cur*2 > b.Max:
+		b.cur = b.Max
+	default:
+		b.cur *= 2
+	}
+	return b.cur
+}
+
+// Reset starts the delays over from Min.
+func (b *Backoff) Reset() {
+	b.cur = 0
+}
@@ -0,0 +1,134 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package manager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// savedCookie is a cookie in the jar file, with the address it came from.
+type savedCookie struct {
+	URL, Name, Value string
+	Domain, Path     string `json:",omitempty"`
+	Expires          time.Time
+	Secure, HttpOnly bool
+}
+
+func (c savedCookie) cookie() *http.Cookie {
+	return &http.Cookie{
+		Name: c.Name, Value: c.Value, Domain: c.Domain, Path: c.Path,
+		Expires: c.Expires, Secure: c.Secure, HttpOnly: c.HttpOnly,
+	}
+}
+
+// fileJar is a cookie jar kept in a file, so a login session survives a
+// restart of the manager.  The file is rewritten whenever a server sets a
+// cookie.
+type fileJar struct {
+	*cookiejar.Jar
+	path string
+
+	mu      sync.Mutex
+	cookies map[string]savedCookie // by URL, name and path
+}
+
+// newJar returns the cookie jar for a manager, kept in the file path or
+// only in memory when path is empty.
+func newJar(path string) http.CookieJar {
+	if path == "" {
+		jar, _ := cookiejar.New(nil) // cookiejar.New() doesn't have an error return path
+		return jar
+	}
+	return openJar(path)
+}
+
+// openJar returns a cookie jar loaded from path.  An unreadable file is
+// logged and a new jar started.
+func openJar(path string) *fileJar {
+	jar, _ := cookiejar.New(nil) // cookiejar.New() doesn't have an error return path
+	j := &fileJar{Jar: jar, path: path, cookies: make(map[string]savedCookie)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return j
+	}
+	var saved []savedCookie
+	if err == nil {
+		err = json.Unmarshal(data, &saved)
+	}
+	if err != nil {
+		log.Println("Ignoring cookie file:", err)
+		return j
+	}
+	now := time.Now()
+	for _, c := range saved {
+		u, err := url.Parse(c.URL)
+		if err != nil || !c.Expires.IsZero() && c.Expires.Before(now) {
+			continue
+		}
+		ck := c.cookie()
+		j.Jar.SetCookies(u, []*http.Cookie{ck})
+		j.cookies[cookieKey(u, ck)] = c
+	}
+	return j
+}
+
+// SetCookies stores cookies in the jar and saves the jar to its file.
+func (j *fileJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.Jar.SetCookies(u, cookies)
+	origin := &url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/"}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, c := range cookies {
+		c := *c
+		key := cookieKey(origin, &c)
+		if c.MaxAge < 0 || !c.Expires.IsZero() && c.Expires.Before(time.Now()) {
+			delete(j.cookies, key)
+			continue
+		}
+		if c.MaxAge > 0 {
+			// Keep the expiry when the jar is loaded again
+			c.Expires = time.Now().Add(time.Duration(c.MaxAge) * time.Second)
+			c.MaxAge = 0
+		}
+		j.cookies[key] = savedCookie{
+			URL: origin.String(), Name: c.Name, Value: c.Value, Domain: c.Domain, Path: c.Path,
+			Expires: c.Expires, Secure: c.Secure, HttpOnly: c.HttpOnly,
+		}
+	}
+	if err := j.save(); err != nil {
+		log.Println("Saving cookies:", err)
+	}
+}
+
+// save writes the jar to its file, readable only by the owner since the
+// cookies stand in for the account password.  j.mu must be held.
+func (j *fileJar) save() error {
+	saved := make([]savedCookie, 0, len(j.cookies))
+	for _, c := range j.cookies {
+		saved = append(saved, c)
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := j.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, j.path)
+}
+
+func cookieKey(u *url.URL, c *http.Cookie) string {
+	return u.Host + "|" + c.Domain + "|" + c.Path + "|" + c.Name
+}
@@ -0,0 +1,54 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package manager
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RequestInterval is the least time between requests to the servers,
+// counted across all devices.
+var RequestInterval = time.Second
+
+// sharedClient returns the HTTP client used by all of a manager's servers.
+func sharedClient(base http.RoundTripper, timeout time.Duration, jar http.CookieJar) *http.Client {
+	return &http.Client{
+		Jar:       jar,
+		Timeout:   timeout,
+		Transport: &limitTransport{base: base, every: RequestInterval},
+	}
+}
+
+// limitTransport spaces out the requests sent through it.
+type limitTransport struct {
+	base  http.RoundTripper
+	every time.Duration
+
+	mu   sync.Mutex
+	next time.Time // earliest start of the next request
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	slot := time.Now()
+	if slot.Before(t.next) {
+		slot = t.next
+	}
+	t.next = slot.Add(t.every)
+	t.mu.Unlock()
+
+	if wait := time.Until(slot); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+	return t.base.RoundTrip(req)
+}
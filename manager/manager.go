@@ -0,0 +1,367 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+// Package manager keeps mfakto and clLucas work directories supplied with
+// assignments and submits their results.
+package manager
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Kunde21/MersenneManager/atomicfile"
+	"github.com/Kunde21/MersenneManager/config"
+	"github.com/Kunde21/MersenneManager/filelock"
+	"github.com/Kunde21/MersenneManager/gpu72"
+	"github.com/Kunde21/MersenneManager/ledger"
+	"github.com/Kunde21/MersenneManager/primenet"
+	"github.com/Kunde21/MersenneManager/primenet/v5api"
+	"github.com/Kunde21/MersenneManager/results"
+	"github.com/Kunde21/MersenneManager/state"
+	"github.com/Kunde21/MersenneManager/worktodo"
+)
+
+// ErrNoWork is returned by Topoff when no assignments could be fetched.
+var ErrNoWork = errors.New("no new work fetched")
+
+// Manager fetches work and submits results for devices.
+// A nil client disables that work source.
+//
+// When V5 is set, Primenet assignments are fetched and results reported
+// through the v5 API, with each device registered as its own computer.
+// V5 is a template; its GUID is replaced by the device's own.
+type Manager struct {
+	Primenet *primenet.Client
+	GPU72    *gpu72.Client
+	V5       *v5api.Client
+	Ledger   string // ledger database path, empty to keep no ledger
+
+	health   health
+	sess     session
+	wake     wake
+	ledgerMu sync.Mutex
+}
+
+// New returns a Manager with a client for each account configured in sett.
+// The clients share one rate limited HTTP client and cookie jar, saved to
+// sett.Cookies when set.
+func New(sett *config.Settings) (*Manager, error) {
+	m := &Manager{Ledger: sett.Ledger}
+	filelock.Advisory = sett.Flock
+	tr, err := newTransport(sett)
+	if err != nil {
+		return nil, err
+	}
+	hc := sharedClient(tr, sett.Timeout(), newJar(sett.Cookies))
+	if sett.Primenet() {
+		m.Primenet = primenet.New(sett.Usrname, sett.Pass)
+		m.Primenet.HTTP = hc
+		if m.Primenet.BaseURL, err = endpoint(sett.PrimenetURL, primenet.DefaultURL); err != nil {
+			return nil, err
+		}
+		warnPlain("Primenet", m.Primenet.BaseURL)
+	}
+	if sett.GPU72() {
+		m.GPU72 = gpu72.New(sett.GPU72Usr, sett.GPU72Pass)
+		m.GPU72.HTTP = hc
+		if m.GPU72.BaseURL, err = endpoint(sett.GPU72URL, gpu72.DefaultURL); err != nil {
+			return nil, err
+		}
+		warnPlain("GPU72", m.GPU72.BaseURL)
+	}
+	if sett.V5 && sett.Usrname != "" {
+		m.V5 = v5api.New(sett.Usrname, "")
+		m.V5.HTTP = hc
+		if m.V5.BaseURL, err = endpoint(sett.V5URL, v5api.DefaultURL); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// warnPlain logs a warning when the password for server would be sent
+// unencrypted.
+func warnPlain(server string, u *url.URL) {
+	if u.Scheme != "https" {
+		log.Printf("Warning: %s password is sent unencrypted to %s", server, u.Redacted())
+	}
+}
+
+// Topoff fills worktodo.txt up to the device's assignment cache size.
+func (m *Manager) Topoff(ctx context.Context, dev *Device) error {
+	if err := filelock.LockContext(ctx, dev.Files.Todo); err != nil {
+		return fmt.Errorf("locking worktodo.txt: %w", err)
+	}
+	defer filelock.Unlock(dev.Files.Todo)
+	curr, err := ioutil.ReadFile(dev.Files.Todo)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	wt := worktodo.Parse(curr)
+	curWrk := wt.Entries(dev.workKinds()...)
+	if len(curWrk) >= int(dev.Cache) {
+		return nil
+	}
+	st, err := state.Open(ctx, dev.Files.Dir)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	n := dev.Cache - uint(len(curWrk))
+	log.Println("Getwork", n)
+	work, source := m.getWork(ctx, n, dev, st)
+	if len(work) == 0 {
+		return fmt.Errorf("%s: %w, %d of %d assignments left", dev.Files.Dir, ErrNoWork, len(curWrk), dev.Cache)
+	}
+	wt.Insert(work...)
+	now := time.Now().UTC()
+	for _, e := range work {
+		a := &state.Assignment{
+			Exponent: e.Exponent,
+			AID:      e.AID,
+			Kind:     e.Kind,
+			Source:   source,
+			Fetched:  now,
+			FFT:      dev.fftLength(e.Exponent),
+		}
+		a.Due = dueDate(a)
+		st.Add(a)
+	}
+	m.noteFetched(dev, source, now, work)
+
+	workFile := wt.Bytes()
+	if err := atomicfile.WriteFile(dev.Files.Todo, workFile); err != nil {
+		log.Println(string(workFile))
+		return fmt.Errorf("worktodo.txt write error: %w", err)
+	}
+	return st.Save()
+}
+
+// useLedger runs f on the ledger database.  The ledger is opened for each
+// use so the ledger command can read it between updates, and devices take
+// turns with it; a pass over a device gathers its updates into one use.
+// Errors are logged rather than holding up the caller.
+func (m *Manager) useLedger(readOnly bool, f func(*ledger.Ledger) error) {
+	if m.Ledger == "" {
+		return
+	}
+	m.ledgerMu.Lock()
+	defer m.ledgerMu.Unlock()
+	led, err := ledger.Open(m.Ledger, readOnly)
+	if err == nil {
+		err = errors.Join(f(led), led.Close())
+	}
+	if err != nil {
+		log.Println("ledger:", err)
+	}
+}
+
+// noteFetched adds new assignments of dev to the ledger.
+func (m *Manager) noteFetched(dev *Device, source string, at time.Time, work []worktodo.Entry) {
+	as := make([]ledger.Assignment, len(work))
+	for i, e := range work {
+		as[i] = ledger.Assignment{
+			Dir:      dev.Files.Dir,
+			Exponent: e.Exponent,
+			AID:      e.AID,
+			Kind:     e.Kind,
+			Source:   source,
+			BitLo:    e.BitLo,
+			BitHi:    e.BitHi,
+			Fetched:  at,
+		}
+	}
+	m.useLedger(false, func(led *ledger.Ledger) error { return led.Fetched(as...) })
+}
+
+func (m *Manager) getWork(ctx context.Context, n uint, dev *Device, st *state.State) (work []worktodo.Entry, source string) {
+	var err error
+	if dev.Kind == Mfakto && m.GPU72 != nil {
+		work, err = m.GPU72.GetWork(ctx, n, dev.WorkType, dev.Target)
+		if err != nil {
+			log.Println(err)
+		}
+		source = state.SourceGPU72
+	}
+	// These will catch GPU72 failures
+	if len(work) == 0 && m.V5 != nil {
+		work, err = m.getWorkV5(ctx, n, dev, st)
+		if err != nil {
+			log.Println(err)
+		}
+		source = state.SourceV5
+	}
+	if len(work) == 0 && m.Primenet != nil {
+		work, err = m.Primenet.GetWork(ctx, n, dev.Pref)
+		if err != nil {
+			log.Println(err)
+		}
+		source = state.SourcePrimenet
+	}
+	if dev.Kind == Mfakto {
+		work = worktodo.SetTargets(dev.Target, work)
+	}
+	kinds := dev.workKinds()
+	kept := work[:0]
+	for _, e := range work {
+		if e.Is(kinds...) {
+			kept = append(kept, e)
+		}
+	}
+	return kept, source
+}
+
+// workKinds returns the worktodo work types run by the device's program.
+func (dev *Device) workKinds() []string {
+	if dev.Kind == ClLucas {
+		return []string{worktodo.Test, worktodo.DoubleCheck}
+	}
+	return []string{worktodo.Factor}
+}
+
+// Update tracks the progress of dev's assignments, tops off worktodo.txt
+// and submits results.  Results are still submitted when no work could be
+// fetched, and are left in results.txt while the manager is degraded.
+func (m *Manager) Update(ctx context.Context, dev *Device) error {
+	if err := m.Track(ctx, dev); err != nil {
+		log.Println(err)
+	}
+	err := errors.Join(m.Topoff(ctx, dev), m.SendResults(ctx, dev))
+	switch {
+	case ctx.Err() != nil:
+		// Shutting down, not an outage
+	case netError(err):
+		m.degrade(err)
+	case err == nil && m.Primenet == nil:
+		m.recovered() // nothing else to tell us the v5 server is back
+	}
+	return err
+}
+
+// SendResults submits completed results to Primenet.  Accepted results are
+// recorded in the device's sent file and refused ones in its rejected file
+// with the server's reason.  Results for exponents still in worktodo.txt,
+// and lines that don't complete an assignment, are held back in results.txt.
+func (m *Manager) SendResults(ctx context.Context, dev *Device) error {
+	if m.Primenet == nil && m.V5 == nil {
+		return nil
+	}
+	locks := []string{dev.Files.Res, dev.Files.Sent, dev.Files.Rejected, dev.Files.Journal, dev.Files.Todo}
+	if err := filelock.LockContext(ctx, locks...); err != nil {
+		return fmt.Errorf("locking results.txt: %w", err)
+	}
+	defer filelock.Unlock(locks...)
+
+	curr, err := ioutil.ReadFile(dev.Files.Res)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	out, err := m.openOutbox(ctx, dev)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	curRes, bad := results.ParseAll(curr)
+	for _, e := range bad {
+		log.Println("Keeping unrecognized result:", e)
+	}
+	if len(curRes) == 0 {
+		return out.compact(nil)
+	}
+	out.seen, out.seenAt = curRes, time.Now().UTC()
+
+	asgn, err := ioutil.ReadFile(dev.Files.Todo)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	wt := worktodo.Parse(asgn)
+	keep, send := results.Filter(curRes, func(exp uint64) bool { return wt.Find(exp) != nil })
+	log.Println("Results:", len(keep)+len(send), "Sending Completed:", len(send))
+
+	// Results the journal shows were already answered are dropped here
+	pending, err := out.recover(send)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	if m.Degraded() {
+		log.Println("Degraded, spooling", len(pending), "results until the servers are back")
+		pending = nil
+	} else if m.V5 != nil {
+		pending, err = m.sendResultsV5(ctx, dev, out, pending)
+		errs = append(errs, err)
+	}
+	if m.Primenet != nil {
+		errs = append(errs, m.sendBatches(ctx, out, pending))
+	} else if len(pending) > 0 {
+		log.Println("No Primenet login for", len(pending), "results without assignment IDs")
+	}
+	errs = append(errs, out.st.Save())
+
+	keepLines := results.Lines(append(keep, out.unsent(send)...))
+	for _, e := range bad {
+		keepLines = append(keepLines, []byte(e.Line))
+	}
+	if err := writeResults(dev.Files.Res, keepLines); err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	errs = append(errs, out.compact(keepLines))
+	return errors.Join(errs...)
+}
+
+// sendBatches submits res through the manual results page.  A failed batch
+// doesn't stop the remaining ones from being sent, but cancelling ctx does.
+// Lines too long for the page are moved to the rejected file.
+func (m *Manager) sendBatches(ctx context.Context, out *outbox, res []results.Result) error {
+	batches, long, _ := results.Batches(res, results.SendLimit)
+	for _, r := range long {
+		if err := out.record(r, false, results.ErrLineTooLong.Error(), 0); err != nil {
+			return err
+		}
+	}
+	var errs []error
+	for _, batch := range batches {
+		if ctx.Err() != nil {
+			// Shutting down, leave the rest pending
+			return errors.Join(append(errs, ctx.Err())...)
+		}
+		if err := out.begin(batch...); err != nil {
+			return err
+		}
+		// A batch that has been started is seen through, so its outcome is known
+		recs, err := m.Primenet.SendBatch(context.WithoutCancel(ctx), results.Join(batch))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sendbatch: %w", err), out.fail(err, batch...))
+			continue
+		}
+		for i, rec := range match(batch, recs) {
+			if err := out.record(batch[i], rec.Status == primenet.Accepted, rec.Status.String()+": "+rec.Detail, rec.Credit); err != nil {
+				return err
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// writeResults replaces the contents of results.txt with lines.
+func writeResults(path string, lines [][]byte) error {
+	var keepRes []byte // All results sent successfully, clear results file
+	if len(lines) > 0 {
+		keepRes = append(bytes.Join(lines, []byte("\n")), byte('\n'))
+	}
+	if err := atomicfile.WriteFile(path, keepRes); err != nil {
+		return fmt.Errorf("write error of kept results: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,440 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kunde21/MersenneManager/config"
+	"github.com/Kunde21/MersenneManager/fakeserver"
+	"github.com/Kunde21/MersenneManager/gpu72"
+	"github.com/Kunde21/MersenneManager/journal"
+	"github.com/Kunde21/MersenneManager/results"
+	"github.com/Kunde21/MersenneManager/state"
+	"github.com/Kunde21/MersenneManager/worktodo"
+)
+
+// newTF returns a manager using the fake Primenet and GPU72 servers, and
+// an mfakto device in a temporary directory.
+func newTF(t *testing.T) (*Manager, *Device, *fakeserver.Primenet, *fakeserver.GPU72) {
+	t.Helper()
+	m, dev, pn := newPrimenet(t)
+	g := fakeserver.NewGPU72("guser", "gpass")
+	t.Cleanup(g.Close)
+	m.GPU72 = gpu72.New("guser", "gpass")
+	m.GPU72.BaseURL = g.BaseURL()
+	return m, dev, pn, g
+}
+
+// exponents returns the exponents of the assignments in dev's worktodo.txt.
+func exponents(dev *Device) []uint64 {
+	var exps []uint64
+	for _, e := range worktodo.Parse([]byte(readFile(dev.Files.Todo))).Entries() {
+		exps = append(exps, e.Exponent)
+	}
+	return exps
+}
+
+// lines returns the non-empty lines of s, sorted.
+func lines(s string) []string {
+	var ls []string
+	for _, l := range strings.Split(s, "\n") {
+		if l != "" {
+			ls = append(ls, l)
+		}
+	}
+	sort.Strings(ls)
+	return ls
+}
+
+func TestTopoff(t *testing.T) {
+	const a, b, c, d = 332197331, 332197369, 332197391, 332197411
+	for _, test := range []struct {
+		name   string
+		setup  func(t *testing.T, m *Manager, dev *Device, pn *fakeserver.Primenet, g *fakeserver.GPU72)
+		want   []uint64
+		source string
+		err    error
+	}{
+		{
+			name: "gpu72",
+			setup: func(t *testing.T, m *Manager, dev *Device, pn *fakeserver.Primenet, g *fakeserver.GPU72) {
+				g.AddWork("lltf", factor(a), factor(b), factor(c))
+				pn.AddWork(2, factor(d))
+			},
+			want:   []uint64{a, b},
+			source: state.SourceGPU72,
+		},
+		{
+			name: "partly full",
+			setup: func(t *testing.T, m *Manager, dev *Device, pn *fakeserver.Primenet, g *fakeserver.GPU72) {
+				writeTodo(t, dev, factor(d))
+				g.AddWork("lltf", factor(a), factor(b))
+			},
+			want:   []uint64{d, a},
+			source: state.SourceGPU72,
+		},
+		{
+			name: "full",
+			setup: func(t *testing.T, m *Manager, dev *Device, pn *fakeserver.Primenet, g *fakeserver.GPU72) {
+				writeTodo(t, dev, factor(c), factor(d))
+				g.AddWork("lltf", factor(a))
+			},
+			want: []uint64{c, d},
+		},
+		{
+			name: "gpu72 hangs",
+			setup: func(t *testing.T, m *Manager, dev *Device, pn *fakeserver.Primenet, g *fakeserver.GPU72) {
+				m.GPU72.HTTP.Timeout = 100 * time.Millisecond
+				g.Script(fakeserver.GPU72GetAssignments, fakeserver.Hang)
+				g.AddWork("lltf", factor(c))
+				pn.AddWork(2, factor(a), factor(b))
+			},
+			want:   []uint64{a, b},
+			source: state.SourcePrimenet,
+		},
+		{
+			name: "gpu72 hangs up",
+			setup: func(t *testing.T, m *Manager, dev *Device, pn *fakeserver.Primenet, g *fakeserver.GPU72) {
+				g.Script(fakeserver.GPU72GetAssignments, fakeserver.Hangup)
+				pn.AddWork(2, factor(a), factor(b))
+			},
+			want:   []uint64{a, b},
+			source: state.SourcePrimenet,
+		},
+		{
+			name: "wrong gpu72 password",
+			setup: func(t *testing.T, m *Manager, dev *Device, pn *fakeserver.Primenet, g *fakeserver.GPU72) {
+				m.GPU72.Pass = "wrong"
+				g.AddWork("lltf", factor(c))
+				pn.AddWork(2, factor(a), factor(b))
+			},
+			want:   []uint64{a, b},
+			source: state.SourcePrimenet,
+		},
+		{
+			name: "expired session",
+			setup: func(t *testing.T, m *Manager, dev *Device, pn *fakeserver.Primenet, g *fakeserver.GPU72) {
+				if err := m.Login(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				pn.Expire()
+				pn.AddWork(2, factor(a), factor(b))
+			},
+			want:   []uint64{a, b},
+			source: state.SourcePrimenet,
+		},
+		{
+			name: "duplicate assignment",
+			setup: func(t *testing.T, m *Manager, dev *Device, pn *fakeserver.Primenet, g *fakeserver.GPU72) {
+				e := factor(a)
+				pn.AddWork(2, e, e)
+			},
+			want:   []uint64{a},
+			source: state.SourcePrimenet,
+		},
+		{
+			name:  "no work",
+			setup: func(t *testing.T, m *Manager, dev *Device, pn *fakeserver.Primenet, g *fakeserver.GPU72) {},
+			err:   ErrNoWork,
+		},
+	} {
+		m, dev, pn, g := newTF(t)
+		test.setup(t, m, dev, pn, g)
+		if err := m.Topoff(context.Background(), dev); !errors.Is(err, test.err) {
+			t.Errorf("%s: err = %v, want %v", test.name, err, test.err)
+		}
+		got := exponents(dev)
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: worktodo.txt exponents = %v, want %v", test.name, got, test.want)
+		}
+		if test.source == "" {
+			continue
+		}
+		st, err := state.Load(dev.Files.Dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := st.Find(got[len(got)-1])
+		if a == nil || a.Source != test.source || a.Due.IsZero() {
+			t.Errorf("%s: saved assignment = %+v, want source %s", test.name, a, test.source)
+		}
+	}
+}
+
+func TestSendResults(t *testing.T) {
+	const a, b = 332197331, 332197369
+	for _, test := range []struct {
+		name  string
+		setup func(t *testing.T, m *Manager, dev *Device, pn *fakeserver.Primenet)
+		kept  []string // left in results.txt
+		sent  []string
+	}{
+		{
+			name:  "accepted",
+			setup: func(t *testing.T, m *Manager, dev *Device, pn *fakeserver.Primenet) {},
+			sent:  []string{tfResult(a), tfResult(b)},
+		},
+		{
+			name: "assignment in progress",
+			setup: func(t *testing.T, m *Manager, dev *Device, pn *fakeserver.Primenet) {
+				writeTodo(t, dev, factor(a))
+			},
+			kept: []string{tfResult(a)},
+			sent: []string{tfResult(b)},
+		},
+		{
+			name: "expired session",
+			setup: func(t *testing.T, m *Manager, dev *Device, pn *fakeserver.Primenet) {
+				if err := m.Login(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				pn.Expire()
+			},
+			sent: []string{tfResult(a), tfResult(b)},
+		},
+	} {
+		m, dev, pn := newPrimenet(t)
+		ioutil.WriteFile(dev.Files.Res, []byte(tfResult(a)+"\n"+tfResult(b)+"\n"), 0664)
+		test.setup(t, m, dev, pn)
+		if err := m.SendResults(context.Background(), dev); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if got := lines(readFile(dev.Files.Res)); fmt.Sprint(got) != fmt.Sprint(lines(strings.Join(test.kept, "\n"))) {
+			t.Errorf("%s: results.txt = %q, want %q", test.name, got, test.kept)
+		}
+		want := lines(strings.Join(test.sent, "\n"))
+		if got := lines(readFile(dev.Files.Sent)); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s: sent file = %q, want %q", test.name, got, want)
+		}
+		if got := pn.Results(); fmt.Sprint(lines(strings.Join(got, "\n"))) != fmt.Sprint(want) {
+			t.Errorf("%s: server results = %q, want %q", test.name, got, want)
+		}
+		if got := readFile(dev.Files.Rejected); got != "" {
+			t.Errorf("%s: rejected file = %q", test.name, got)
+		}
+	}
+}
+
+func TestSendDuplicate(t *testing.T) {
+	m, dev, pn := newPrimenet(t)
+	ctx := context.Background()
+	line := tfResult(332197331)
+	for i := 0; i < 2; i++ {
+		ioutil.WriteFile(dev.Files.Res, []byte(line+"\n"), 0664)
+		if err := m.SendResults(ctx, dev); err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+	}
+	if got := readFile(dev.Files.Sent); got != line+"\n" {
+		t.Errorf("sent file = %q", got)
+	}
+	if got := readFile(dev.Files.Rejected); !strings.HasPrefix(got, line+"\t# duplicate") {
+		t.Errorf("rejected file = %q", got)
+	}
+	if got := readFile(dev.Files.Res); got != "" {
+		t.Errorf("results.txt = %q", got)
+	}
+	if n := len(pn.Results()); n != 1 {
+		t.Errorf("%d results stored", n)
+	}
+}
+
+func TestSendLongLine(t *testing.T) {
+	m, dev, pn := newPrimenet(t)
+	long := strings.Replace(tfResult(332197369), "mfakto", strings.Repeat("x", results.SendLimit), 1)
+	good := []string{tfResult(332197331), tfResult(332197391)}
+	ioutil.WriteFile(dev.Files.Res, []byte(good[0]+"\n"+long+"\n"+good[1]+"\n"), 0664)
+	if err := m.SendResults(context.Background(), dev); err != nil {
+		t.Fatal(err)
+	}
+	if got := lines(strings.Join(pn.Results(), "\n")); fmt.Sprint(got) != fmt.Sprint(good) {
+		t.Errorf("server results = %.200q", got)
+	}
+	if got := readFile(dev.Files.Rejected); !strings.HasPrefix(got, long+"\t# ") {
+		t.Errorf("rejected file = %.200q", got)
+	}
+	if got := readFile(dev.Files.Res); got != "" {
+		t.Errorf("results.txt = %.200q", got)
+	}
+}
+
+func TestSendHangup(t *testing.T) {
+	m, dev, pn := newPrimenet(t)
+	ctx := context.Background()
+	first, second := tfResult(332197331), tfResult(332197369)
+	ioutil.WriteFile(dev.Files.Res, []byte(first+"\n"), 0664)
+	pn.Script(fakeserver.PrimenetResult, fakeserver.Hangup)
+	if err := m.SendResults(ctx, dev); err == nil {
+		t.Error("dropped connection: no error")
+	}
+	if got := readFile(dev.Files.Res); got != first+"\n" {
+		t.Errorf("results.txt after the failed send = %q", got)
+	}
+
+	// The lost submission isn't sent again without the user checking it
+	ioutil.WriteFile(dev.Files.Res, []byte(first+"\n"+second+"\n"), 0664)
+	if err := m.SendResults(ctx, dev); err != nil {
+		t.Fatal(err)
+	}
+	if got := pn.Results(); len(got) != 1 || got[0] != second {
+		t.Errorf("server results = %q", got)
+	}
+	if got := readFile(dev.Files.Rejected); !strings.HasPrefix(got, first+"\t# unconfirmed") {
+		t.Errorf("rejected file = %q", got)
+	}
+	if got := readFile(dev.Files.Sent); got != second+"\n" {
+		t.Errorf("sent file = %q", got)
+	}
+}
+
+func TestSendPrunesJournal(t *testing.T) {
+	m, dev, pn := newPrimenet(t)
+	ctx := context.Background()
+	first, second := tfResult(332197331), tfResult(332197369)
+	ioutil.WriteFile(dev.Files.Res, []byte(first+"\n"), 0664)
+	pn.Script(fakeserver.PrimenetResult, fakeserver.Hangup)
+	if err := m.SendResults(ctx, dev); err == nil {
+		t.Error("dropped connection: no error")
+	}
+
+	// The user removes the unconfirmed result by hand
+	ioutil.WriteFile(dev.Files.Res, []byte(second+"\n"), 0664)
+	if err := m.SendResults(ctx, dev); err != nil {
+		t.Fatal(err)
+	}
+	j, err := journal.Open(dev.Files.Journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	for _, l := range []string{first, second} {
+		if s := j.State(journal.ID(l)); s != journal.None {
+			t.Errorf("%.30s: journal state %q after leaving results.txt", l, s)
+		}
+	}
+}
+
+func TestRunDevice(t *testing.T) {
+	const a, b, c = 332197331, 332197369, 332197391
+	// Retry the login on the next pass after one fails
+	defer func(d time.Duration) { loginRetry = d }(loginRetry)
+	loginRetry = 0
+	for _, test := range []struct {
+		name  string
+		setup func(pn *fakeserver.Primenet, g *fakeserver.GPU72)
+		// requests made to the login and GPU72 pages
+		logins, gets int
+	}{
+		{
+			name:   "first pass",
+			setup:  func(pn *fakeserver.Primenet, g *fakeserver.GPU72) {},
+			logins: 1, gets: 1,
+		},
+		{
+			name: "gpu72 hangs up",
+			setup: func(pn *fakeserver.Primenet, g *fakeserver.GPU72) {
+				g.Script(fakeserver.GPU72GetAssignments, fakeserver.Hangup)
+			},
+			logins: 1, gets: 2,
+		},
+		{
+			name: "login hangs up",
+			setup: func(pn *fakeserver.Primenet, g *fakeserver.GPU72) {
+				pn.Script(fakeserver.PrimenetLogin, fakeserver.Hangup)
+			},
+			// Results are spooled until the second pass
+			logins: 2, gets: 1,
+		},
+	} {
+
+		m, dev, pn, g := newTF(t)
+		g.AddWork("lltf", factor(a), factor(b))
+		ioutil.WriteFile(dev.Files.Res, []byte(tfResult(c)+"\n"), 0664)
+		test.setup(pn, g)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		m.RunDevice(ctx, 0, dev, 0, Backoff{Min: 10 * time.Millisecond, Max: 40 * time.Millisecond})
+		if ctx.Err() != nil {
+			t.Errorf("%s: no complete update", test.name)
+		}
+		cancel()
+		if m.Degraded() {
+			t.Errorf("%s: still degraded", test.name)
+		}
+		if got := exponents(dev); fmt.Sprint(got) != fmt.Sprint([]uint64{a, b}) {
+			t.Errorf("%s: worktodo.txt exponents = %v", test.name, got)
+		}
+		if got := readFile(dev.Files.Sent); got != tfResult(c)+"\n" {
+			t.Errorf("%s: sent file = %q", test.name, got)
+		}
+		if n := pn.Hits(fakeserver.PrimenetLogin); n != test.logins {
+			t.Errorf("%s: %d login requests, want %d", test.name, n, test.logins)
+		}
+		if n := g.Hits(fakeserver.GPU72GetAssignments); n != test.gets {
+			t.Errorf("%s: %d GPU72 requests, want %d", test.name, n, test.gets)
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	m, dev, pn, g := newTF(t)
+	other, err := NewDevice(Mfakto, config.Device{Workdir: t.TempDir(), Cache: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	devs := []*Device{dev, other}
+	g.AddWork("lltf", factor(332197331), factor(332197369), factor(332197391), factor(332197411))
+	for i, d := range devs {
+		ioutil.WriteFile(d.Files.Res, []byte(tfResult(uint64(332197433+i*2))+"\n"), 0664)
+	}
+	st := &Setup{Manager: m, Devices: devs, Poll: time.Hour, Retry: Backoff{Min: 10 * time.Millisecond, Max: 40 * time.Millisecond}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Serve(ctx, st, func(context.Context) (*Setup, error) { return nil, errors.New("no reload") })
+	}()
+	for deadline := time.Now().Add(5 * time.Second); len(pn.Results()) < 2; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("results sent: %q", pn.Results())
+		}
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after cancel")
+	}
+
+	seen := make(map[uint64]bool)
+	for i, d := range devs {
+		exps := exponents(d)
+		if len(exps) != 2 {
+			t.Errorf("device %d: worktodo.txt exponents = %v", i, exps)
+		}
+		for _, e := range exps {
+			if seen[e] {
+				t.Errorf("M%d given to two devices", e)
+			}
+			seen[e] = true
+		}
+		if got := readFile(d.Files.Res); got != "" {
+			t.Errorf("device %d: results.txt = %q", i, got)
+		}
+	}
+	// One update per device before waiting out the hour
+	if n := g.Hits(fakeserver.GPU72GetAssignments); n != 2 {
+		t.Errorf("%d GPU72 requests, want 2", n)
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package manager
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Kunde21/MersenneManager/primenet/v5api"
+	"github.com/Kunde21/MersenneManager/state"
+	"github.com/Kunde21/MersenneManager/worktodo"
+)
+
+const day = 24 * time.Hour
+
+// Expiry is how long the servers allow for each work type before an
+// unfinished assignment is reclaimed.  Other work types get 30 days.
+var Expiry = map[string]time.Duration{
+	worktodo.Factor:      30 * day,
+	worktodo.Test:        180 * day,
+	worktodo.DoubleCheck: 150 * day,
+}
+
+// ExpiryWarning is how close to its due date an unfinished assignment
+// is reported as at risk.
+var ExpiryWarning = 7 * day
+
+// ReportEvery is the interval between v5 progress reports.
+var ReportEvery = day
+
+// dueDate returns when a is expected to expire.
+func dueDate(a *state.Assignment) time.Time {
+	if !a.Due.IsZero() {
+		return a.Due
+	}
+	exp, ok := Expiry[a.Kind]
+	if !ok {
+		exp = 30 * day
+	}
+	return a.Fetched.Add(exp)
+}
+
+var (
+	mfaktoStart   = regexp.MustCompile(`M(\d+) from 2\^`)
+	mfaktoClass   = regexp.MustCompile(`\|\s*\d+\s+(\d+(?:\.\d+)?)%`)
+	clLucasStatus = regexp.MustCompile(`Iteration\s+(\d+)\s+M\(\s*(\d+)\s*\)`)
+)
+
+// progress holds the last progress line read from a worker.
+type progress struct {
+	sync.Mutex
+	exponent uint64
+	percent  float64
+	at       time.Time
+}
+
+// observe picks the current exponent and percent done out of a line of
+// mfakto or clLucas output.
+func (p *progress) observe(line string) {
+	p.Lock()
+	defer p.Unlock()
+	if m := mfaktoStart.FindStringSubmatch(line); m != nil {
+		p.exponent, _ = strconv.ParseUint(m[1], 10, 64)
+		p.percent, p.at = 0, time.Now()
+		return
+	}
+	if m := mfaktoClass.FindStringSubmatch(line); m != nil && p.exponent != 0 {
+		p.percent, _ = strconv.ParseFloat(m[1], 64)
+		p.at = time.Now()
+		return
+	}
+	if m := clLucasStatus.FindStringSubmatch(line); m != nil {
+		iter, _ := strconv.ParseFloat(m[1], 64)
+		exp, _ := strconv.ParseUint(m[2], 10, 64)
+		if exp > 0 {
+			p.exponent, p.percent, p.at = exp, 100*iter/float64(exp), time.Now()
+		}
+	}
+}
+
+func (p *progress) get() (exponent uint64, percent float64, at time.Time) {
+	p.Lock()
+	defer p.Unlock()
+	return p.exponent, p.percent, p.at
+}
+
+// Track records when each assignment in worktodo.txt was obtained and how
+// far along the first one is, reports progress of v5 assignments to
+// PrimeNet, and logs a warning for assignments at risk of expiring.
+func (m *Manager) Track(ctx context.Context, dev *Device) error {
+	data, err := ioutil.ReadFile(dev.Files.Todo)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	st, err := state.Open(ctx, dev.Files.Dir)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	ents := worktodo.Parse(data).Entries(dev.workKinds()...)
+	now := time.Now().UTC()
+	for i, e := range ents {
+		a := st.Find(e.Exponent)
+		if a == nil {
+			// Added by hand, start the clock when it is first seen
+			a = &state.Assignment{Exponent: e.Exponent, AID: e.AID, Kind: e.Kind, Fetched: now}
+			st.Add(a)
+		}
+		if i > 0 {
+			continue
+		}
+		if a.Started.IsZero() {
+			a.Started = now
+		}
+		if exp, pct, at := dev.prog.get(); exp == e.Exponent && at.After(a.Updated) {
+			a.Percent, a.Updated = pct, at.UTC()
+		}
+	}
+
+	etas := estimate(st, ents, now)
+	for i, e := range ents {
+		a := st.Find(e.Exponent)
+		if atRisk(a, etas[i], now) {
+			log.Printf("WARNING: M%d in %s is due %s, estimated done %s",
+				e.Exponent, dev.Files.Dir, dueDate(a).Format("2006-01-02"), fmtETA(etas[i]))
+		}
+	}
+	if m.V5 != nil {
+		if err := m.reportProgress(ctx, dev, st, ents, etas, now); err != nil {
+			log.Println(err)
+		}
+	}
+	return st.Save()
+}
+
+// reportProgress sends an ap transaction for each v5 assignment that hasn't
+// been reported within ReportEvery.
+func (m *Manager) reportProgress(ctx context.Context, dev *Device, st *state.State, ents []*worktodo.Entry, etas []time.Time, now time.Time) error {
+	var c *v5api.Client
+	for i, e := range ents {
+		a := st.Find(e.Exponent)
+		if a.Source != state.SourceV5 || a.AID == "" || now.Sub(a.Reported) < ReportEvery {
+			continue
+		}
+		if c == nil {
+			var err error
+			if c, err = m.v5Client(ctx, dev, st); err != nil {
+				return err
+			}
+		}
+		p := v5api.Progress{Stage: "LL", Next: ReportEvery, FFTLen: a.FFT}
+		if dev.Kind == Mfakto {
+			p.Stage = "TF"
+		}
+		if i == 0 {
+			p.Percent = a.Percent
+		}
+		if !etas[i].IsZero() {
+			p.Complete = etas[i].Sub(now)
+		}
+		if _, err := c.ReportProgress(ctx, a.AID, p); err != nil {
+			return err
+		}
+		a.Reported = now
+	}
+	return nil
+}
+
+// estimate returns the expected completion time of each entry, working
+// down worktodo.txt from the progress of the first one.  Times are zero
+// once there is nothing to base them on.
+func estimate(st *state.State, ents []*worktodo.Entry, now time.Time) []time.Time {
+	etas := make([]time.Time, len(ents))
+	each := st.AvgTime
+	t := now
+	for i, e := range ents {
+		a := st.Find(e.Exponent)
+		var left time.Duration
+		switch {
+		case i == 0 && a.Percent > 0 && a.Updated.After(a.Started):
+			total := time.Duration(float64(a.Updated.Sub(a.Started)) * 100 / a.Percent)
+			if each == 0 {
+				each = total
+			}
+			left = total - now.Sub(a.Started)
+		case each == 0:
+			return etas
+		case i == 0 && !a.Started.IsZero():
+			left = each - now.Sub(a.Started)
+		default:
+			left = each
+		}
+		if left > 0 {
+			t = t.Add(left)
+		}
+		etas[i] = t
+	}
+	return etas
+}
+
+// atRisk reports whether a is expected to finish after its due date, or,
+// without an estimate, is within ExpiryWarning of it.
+func atRisk(a *state.Assignment, eta, now time.Time) bool {
+	due := dueDate(a)
+	if !eta.IsZero() {
+		return eta.After(due)
+	}
+	return now.Add(ExpiryWarning).After(due)
+}
+
+func fmtETA(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	return t.Format("2006-01-02 15:04")
+}
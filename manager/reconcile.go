@@ -0,0 +1,247 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/Kunde21/MersenneManager/atomicfile"
+	"github.com/Kunde21/MersenneManager/filelock"
+	"github.com/Kunde21/MersenneManager/ledger"
+	"github.com/Kunde21/MersenneManager/state"
+	"github.com/Kunde21/MersenneManager/worktodo"
+)
+
+// Orphan is an assignment held on only one side: by the server but by no
+// device, or by a device but no longer by the server.
+type Orphan struct {
+	Entry  worktodo.Entry
+	Source string  // server list the assignment belongs on
+	Dir    string  // work directory holding it or that it was fetched for
+	Dev    *Device // configured device for Dir, if any
+	Known  bool    // the ledger shows this manager fetched it
+}
+
+// Reconciliation is the difference between the devices' worktodo files and
+// the assignment lists of the servers.
+type Reconciliation struct {
+	Missing []Orphan // reserved on the server, in no worktodo.txt
+	Gone    []Orphan // in worktodo.txt, expired or reassigned on the server
+
+	// Empty lists the servers whose assignment list was empty although
+	// the devices hold reservations from them.  The list was more likely
+	// misread than emptied, so it is no basis for changing worktodo.txt.
+	Empty []string
+}
+
+// Reconcile compares the assignments in every device's worktodo.txt with
+// the account assignment lists of Primenet and GPU72.
+//
+// The Primenet list holds the account's assignments from every computer,
+// so Missing assignments are marked Known only when the ledger shows this
+// manager fetched them.
+func (m *Manager) Reconcile(ctx context.Context, devs []*Device) (*Reconciliation, error) {
+	server := make(map[string]map[uint64]worktodo.Entry)
+	if m.Primenet != nil {
+		ents, err := m.Primenet.Assignments(ctx)
+		if err != nil {
+			return nil, err
+		}
+		server[state.SourcePrimenet] = byExponent(ents)
+	}
+	if m.GPU72 != nil {
+		ents, err := m.GPU72.Assignments(ctx)
+		if err != nil {
+			return nil, err
+		}
+		server[state.SourceGPU72] = byExponent(ents)
+	}
+	if len(server) == 0 {
+		return nil, errors.New("reconcile: no Primenet or GPU72 account")
+	}
+
+	rec := &Reconciliation{}
+	local := make(map[uint64]bool)
+	held := make(map[string]int) // reservations in worktodo.txt by source
+	for _, dev := range devs {
+		data, err := ioutil.ReadFile(dev.Files.Todo)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		st, err := state.Load(dev.Files.Dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range worktodo.Parse(data).Entries(dev.workKinds()...) {
+			local[e.Exponent] = true
+			if e.AID == "" || e.AID == "N/A" {
+				continue // not a reservation
+			}
+			src := state.SourcePrimenet
+			if a := st.Find(e.Exponent); a != nil && a.Source == state.SourceGPU72 {
+				src = state.SourceGPU72
+			}
+			list, fetched := server[src]
+			if !fetched {
+				continue
+			}
+			held[src]++
+			if _, ok := list[e.Exponent]; !ok {
+				rec.Gone = append(rec.Gone, Orphan{Entry: *e, Source: src, Dir: dev.Files.Dir, Dev: dev, Known: true})
+			}
+		}
+	}
+
+	for src, list := range server {
+		if len(list) == 0 && held[src] > 0 {
+			rec.Empty = append(rec.Empty, src)
+		}
+	}
+	sort.Strings(rec.Empty)
+
+	known := m.fetchedBy()
+	for src, list := range server {
+		for exp, e := range list {
+			if local[exp] {
+				continue
+			}
+			o := Orphan{Entry: e, Source: src}
+			if a, ok := known[exp]; ok {
+				o.Known, o.Dir = true, a.Dir
+				o.Dev = deviceIn(devs, a.Dir)
+				o.Entry = fillEntry(e, a)
+			}
+			rec.Missing = append(rec.Missing, o)
+		}
+	}
+	return rec, nil
+}
+
+func byExponent(ents []worktodo.Entry) map[uint64]worktodo.Entry {
+	byExp := make(map[uint64]worktodo.Entry, len(ents))
+	for _, e := range ents {
+		byExp[e.Exponent] = e
+	}
+	return byExp
+}
+
+// fetchedBy returns the newest open ledger assignment of each exponent.
+func (m *Manager) fetchedBy() map[uint64]ledger.Assignment {
+	known := make(map[uint64]ledger.Assignment)
+	var as []ledger.Assignment
+	m.useLedger(true, func(led *ledger.Ledger) (err error) {
+		as, err = led.Assignments(func(a *ledger.Assignment) bool { return a.Open() })
+		return err
+	})
+	for _, a := range as {
+		if prev, ok := known[a.Exponent]; !ok || a.Fetched.After(prev.Fetched) {
+			known[a.Exponent] = a
+		}
+	}
+	return known
+}
+
+func deviceIn(devs []*Device, dir string) *Device {
+	for _, dev := range devs {
+		if dev.Files.Dir == dir {
+			return dev
+		}
+	}
+	return nil
+}
+
+// fillEntry completes a server list entry with what the ledger recorded
+// when it was fetched.
+func fillEntry(e worktodo.Entry, a ledger.Assignment) worktodo.Entry {
+	if e.Kind == "" {
+		e.Kind = a.Kind
+	}
+	if e.AID == "" {
+		e.AID = a.AID
+	}
+	if e.BitHi == 0 {
+		e.BitLo, e.BitHi = a.BitLo, a.BitHi
+	}
+	return e
+}
+
+// Drop removes a Gone assignment from its device's worktodo.txt.
+func (m *Manager) Drop(ctx context.Context, o Orphan) error {
+	_, err := m.Unreserve(ctx, o.Dev, true, func(_ int, e *worktodo.Entry) bool { return e.Exponent == o.Entry.Exponent })
+	return err
+}
+
+// Release unreserves a Missing assignment on the server.
+func (m *Manager) Release(ctx context.Context, o Orphan) error {
+	if o.Source == state.SourceGPU72 {
+		return fmt.Errorf("M%d: %w", o.Entry.Exponent, errReleaseByHand)
+	}
+	if err := m.Primenet.Unreserve(ctx, o.Entry.String()); err != nil {
+		return err
+	}
+	m.useLedger(false, func(led *ledger.Ledger) error {
+		return led.Finish(o.Dir, o.Entry.Exponent, time.Now().UTC(), true)
+	})
+	return nil
+}
+
+// Readd puts a Missing assignment back into the worktodo.txt of the device
+// it was fetched for, or of the first device that runs its work type.
+func (m *Manager) Readd(ctx context.Context, devs []*Device, o Orphan) error {
+	dev := o.Dev
+	for i := 0; dev == nil && i < len(devs); i++ {
+		if o.Entry.Is(devs[i].workKinds()...) {
+			dev = devs[i]
+		}
+	}
+	switch {
+	case dev == nil:
+		return fmt.Errorf("M%d: no device runs %q work", o.Entry.Exponent, o.Entry.Kind)
+	case !o.Entry.Is(dev.workKinds()...):
+		return fmt.Errorf("M%d: %v device can't run %q work", o.Entry.Exponent, dev.Kind, o.Entry.Kind)
+	case o.Entry.Is(worktodo.Factor) && o.Entry.BitHi == 0:
+		return fmt.Errorf("M%d: bit levels unknown, can't rebuild the assignment", o.Entry.Exponent)
+	}
+
+	if err := filelock.LockContext(ctx, dev.Files.Todo); err != nil {
+		return fmt.Errorf("locking worktodo.txt: %w", err)
+	}
+	defer filelock.Unlock(dev.Files.Todo)
+	curr, err := ioutil.ReadFile(dev.Files.Todo)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	st, err := state.Open(ctx, dev.Files.Dir)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	wt := worktodo.Parse(curr)
+	wt.Insert(o.Entry)
+	if err := atomicfile.WriteFile(dev.Files.Todo, wt.Bytes()); err != nil {
+		return err
+	}
+	if st.Find(o.Entry.Exponent) == nil {
+		a := &state.Assignment{
+			Exponent: o.Entry.Exponent,
+			AID:      o.Entry.AID,
+			Kind:     o.Entry.Kind,
+			Source:   o.Source,
+			Fetched:  time.Now().UTC(),
+			FFT:      dev.fftLength(o.Entry.Exponent),
+		}
+		a.Due = dueDate(a)
+		st.Add(a)
+	}
+	log.Printf("Re-added M%d to %s", o.Entry.Exponent, dev.Files.Dir)
+	return st.Save()
+}
@@ -0,0 +1,100 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package manager
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/Kunde21/MersenneManager/fakeserver"
+	"github.com/Kunde21/MersenneManager/gpu72"
+	"github.com/Kunde21/MersenneManager/state"
+	"github.com/Kunde21/MersenneManager/worktodo"
+)
+
+func factor(exponent uint64) worktodo.Entry {
+	return worktodo.Entry{Kind: worktodo.Factor, AID: fakeserver.NewAID(), Exponent: exponent, BitLo: 74, BitHi: 75}
+}
+
+// writeTodo writes the entries to dev's worktodo.txt.
+func writeTodo(t *testing.T, dev *Device, work ...worktodo.Entry) {
+	t.Helper()
+	wt := worktodo.Parse(nil)
+	wt.Insert(work...)
+	if err := ioutil.WriteFile(dev.Files.Todo, wt.Bytes(), 0664); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReconcileFix(t *testing.T) {
+	m, dev, srv := newPrimenet(t)
+	ctx := context.Background()
+	held, gone, missing := factor(332197331), factor(332197333), factor(332197357)
+	srv.Reserve(held, missing)
+	writeTodo(t, dev, held, gone)
+
+	var out bytes.Buffer
+	if err := m.reconcileCmd(ctx, &out, []*Device{dev}, []string{"-drop", "-readd", "-all"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "1 missing locally, 1 no longer assigned") {
+		t.Errorf("report:\n%s", out.String())
+	}
+	wt := worktodo.Parse([]byte(readFile(dev.Files.Todo)))
+	if wt.Find(held.Exponent) == nil || wt.Find(gone.Exponent) != nil || wt.Find(missing.Exponent) == nil {
+		t.Errorf("worktodo.txt after reconcile:\n%s", wt.Bytes())
+	}
+	if st, _ := state.Load(dev.Files.Dir); st.Find(missing.Exponent) == nil {
+		t.Error("re-added assignment not in the state file")
+	}
+}
+
+func TestReconcileEmptyList(t *testing.T) {
+	m, dev, _ := newPrimenet(t)
+	g := fakeserver.NewGPU72("user", "pass")
+	defer g.Close()
+	m.GPU72 = gpu72.New("user", "pass")
+	m.GPU72.BaseURL = g.BaseURL()
+	ctx := context.Background()
+
+	// Primenet lists nothing, as when the workload page changes, while
+	// worktodo.txt holds a Primenet and a GPU72 assignment
+	g.AddWork("lltf", factor(332197333))
+	if err := m.Topoff(ctx, dev); err != nil {
+		t.Fatal(err)
+	}
+	wt := worktodo.Parse([]byte(readFile(dev.Files.Todo)))
+	wt.Insert(factor(332197331))
+	ioutil.WriteFile(dev.Files.Todo, wt.Bytes(), 0664)
+
+	rec, err := m.Reconcile(ctx, []*Device{dev})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.Empty) != 1 || rec.Empty[0] != state.SourcePrimenet {
+		t.Errorf("Empty = %v, want [%s]", rec.Empty, state.SourcePrimenet)
+	}
+
+	before := readFile(dev.Files.Todo)
+	for _, args := range [][]string{{"-drop"}, {"-readd", "-all"}} {
+		var out bytes.Buffer
+		err := m.reconcileCmd(ctx, &out, []*Device{dev}, args)
+		if err == nil || !strings.Contains(err.Error(), "primenet assignment list is empty") {
+			t.Errorf("%v: err = %v", args, err)
+		}
+		if got := readFile(dev.Files.Todo); got != before {
+			t.Errorf("%v: worktodo.txt changed to:\n%s", args, got)
+		}
+	}
+
+	// Reporting alone is allowed
+	var out bytes.Buffer
+	if err := m.reconcileCmd(ctx, &out, []*Device{dev}, nil); err != nil {
+		t.Errorf("report: %v", err)
+	}
+}
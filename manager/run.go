@@ -0,0 +1,63 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package manager
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Run updates each device in its own goroutine until ctx is cancelled,
+// returning once they have all stopped.  See RunDevice.
+func (m *Manager) Run(ctx context.Context, devs []*Device, poll time.Duration, retry Backoff) {
+	var wg sync.WaitGroup
+	for i, dev := range devs {
+		wg.Add(1)
+		go func(n int, dev *Device) {
+			defer wg.Done()
+			m.RunDevice(ctx, n, dev, poll, retry)
+		}(i, dev)
+	}
+	wg.Wait()
+}
+
+// RunDevice updates dev every poll period until ctx is cancelled.  An
+// update that fails, or runs while the servers are unreachable, is retried
+// after the delays given by retry; a device never gives up because a server
+// is down, and its failures don't hold up other devices.  With a poll of 0,
+// RunDevice returns after the first complete update.  PollNow cuts the wait
+// between updates short.  n numbers the device in log messages.
+func (m *Manager) RunDevice(ctx context.Context, n int, dev *Device, poll time.Duration, retry Backoff) {
+	for {
+		m.login(ctx) // failures are logged and leave the manager degraded
+		log.Printf("Updating %v device: %d", dev.Kind, n)
+		err := m.Update(ctx, dev)
+		if ctx.Err() != nil {
+			return
+		}
+
+		delay := poll
+		if err != nil || m.Degraded() {
+			if err != nil {
+				log.Printf("Device %d: %v", n, err)
+			}
+			if delay = retry.Next(); poll > 0 && poll < delay {
+				delay = poll
+			}
+			log.Printf("Device %d update incomplete, retry in %v", n, delay)
+		} else {
+			retry.Reset()
+			log.Printf("Device %d update complete", n)
+			if poll == 0 {
+				return
+			}
+		}
+		if !m.sleep(ctx, delay) {
+			return
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package manager
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// Setup is a manager with its devices and schedule, as built from a
+// settings file.
+type Setup struct {
+	Manager *Manager
+	Devices []*Device
+	Poll    time.Duration
+	Retry   Backoff
+}
+
+// Serve runs the workers and update loops of st until ctx is cancelled,
+// or until the first complete pass when st.Poll is 0.  On shutdown the
+// current file operation and any submission already sent are finished,
+// locks are released and the workers are stopped before Serve returns.
+//
+// Where the platform has them, SIGHUP stops the devices, calls reload
+// and starts the new setup, carrying on with the old one if reload fails,
+// and SIGUSR1 starts an update of every device at once.
+func Serve(ctx context.Context, st *Setup, reload func(context.Context) (*Setup, error)) {
+	var sigs []os.Signal
+	for _, s := range []os.Signal{reloadSignal, pollSignal} {
+		if s != nil {
+			sigs = append(sigs, s)
+		}
+	}
+	ctl := make(chan os.Signal, 1)
+	if len(sigs) > 0 {
+		signal.Notify(ctl, sigs...)
+		defer signal.Stop(ctl)
+	}
+
+	for {
+		runCtx, cancel := context.WithCancel(ctx)
+		wait := StartWorkers(runCtx, st.Devices)
+		done := make(chan struct{})
+		go func(st *Setup) {
+			defer close(done)
+			st.Manager.Run(runCtx, st.Devices, st.Poll, st.Retry)
+		}(st)
+
+		reloading := false
+	waiting:
+		for {
+			select {
+			case <-done:
+				break waiting
+			case <-ctx.Done():
+				break waiting
+			case sig := <-ctl:
+				if sig == reloadSignal {
+					log.Println("Reloading settings")
+					reloading = true
+					break waiting
+				}
+				log.Println("Polling now")
+				st.Manager.PollNow()
+			}
+		}
+		cancel()
+		<-done
+		wait()
+		if !reloading || ctx.Err() != nil {
+			return
+		}
+		next, err := reload(ctx)
+		if err != nil {
+			log.Println("Reload failed, keeping the current settings:", err)
+			continue
+		}
+		st = next
+	}
+}
+
+// wake lets PollNow interrupt the devices' sleep between updates.
+type wake struct {
+	sync.Mutex
+	ch chan struct{}
+}
+
+// PollNow wakes every device waiting in Run for an immediate update.
+func (m *Manager) PollNow() {
+	m.wake.Lock()
+	defer m.wake.Unlock()
+	if m.wake.ch != nil {
+		close(m.wake.ch)
+	}
+	m.wake.ch = make(chan struct{})
+}
+
+// sleep pauses for d or until PollNow is called, returning false early if
+// ctx is cancelled.
+func (m *Manager) sleep(ctx context.Context, d time.Duration) bool {
+	m.wake.Lock()
+	if m.wake.ch == nil {
+		m.wake.ch = make(chan struct{})
+	}
+	woken := m.wake.ch
+	m.wake.Unlock()
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-woken:
+	case <-t.C:
+	}
+	return true
+}
@@ -0,0 +1,18 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+//go:build !windows
+
+package manager
+
+import (
+	"os"
+	"syscall"
+)
+
+// Control signals handled by Serve.
+var (
+	reloadSignal os.Signal = syscall.SIGHUP
+	pollSignal   os.Signal = syscall.SIGUSR1
+)
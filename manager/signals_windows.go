@@ -0,0 +1,13 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package manager
+
+import "os"
+
+// Windows has no SIGHUP or SIGUSR1, so Serve only handles shutdown.
+var (
+	reloadSignal os.Signal
+	pollSignal   os.Signal
+)
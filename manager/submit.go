@@ -0,0 +1,232 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/Kunde21/MersenneManager/journal"
+	"github.com/Kunde21/MersenneManager/ledger"
+	"github.com/Kunde21/MersenneManager/primenet"
+	"github.com/Kunde21/MersenneManager/primenet/v5api"
+	"github.com/Kunde21/MersenneManager/results"
+	"github.com/Kunde21/MersenneManager/state"
+)
+
+// outbox records the outcome of each submitted result, journaling every
+// submission so that no result is sent twice.
+type outbox struct {
+	sent, rejected *os.File
+	st             *state.State
+	j              *journal.Journal
+	m              *Manager // for the ledger
+	dir            string
+
+	// Ledger updates, written when the outbox is closed
+	seen    []results.Result
+	seenAt  time.Time
+	answers []answer
+}
+
+// answer is the server's answer to a submitted result.
+type answer struct {
+	r        results.Result
+	accepted bool
+	reason   string
+	credit   float64
+	at       time.Time
+}
+
+func (m *Manager) openOutbox(ctx context.Context, dev *Device) (*outbox, error) {
+	st, err := state.Open(ctx, dev.Files.Dir)
+	if err != nil {
+		return nil, err
+	}
+	j, err := journal.Open(dev.Files.Journal)
+	if err != nil {
+		st.Close()
+		return nil, err
+	}
+	sent, err := os.OpenFile(dev.Files.Sent, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0664)
+	if err != nil {
+		st.Close()
+		j.Close()
+		return nil, err
+	}
+	rejected, err := os.OpenFile(dev.Files.Rejected, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0664)
+	if err != nil {
+		st.Close()
+		j.Close()
+		sent.Close()
+		return nil, err
+	}
+	return &outbox{sent: sent, rejected: rejected, st: st, j: j, m: m, dir: dev.Files.Dir}, nil
+}
+
+// recover checks res against the journal.  Results already answered by the
+// server are dropped.  Results whose submission was interrupted are not
+// sent again: they are moved to the rejected file for the user to check.
+// The remaining results are journaled as pending and returned.
+func (o *outbox) recover(res []results.Result) (pending []results.Result, err error) {
+	var fresh []journal.Entry
+	for _, r := range res {
+		switch o.j.State(journal.ID(r.Line)) {
+		case journal.Acked, journal.Rejected:
+			continue
+		case journal.InFlight:
+			err := o.record(r, false, "unconfirmed: submission was interrupted, check mersenne.org before resubmitting", 0)
+			if err != nil {
+				return pending, err
+			}
+			continue
+		case journal.None:
+			fresh = append(fresh, entry(r))
+		}
+		pending = append(pending, r)
+	}
+	return pending, o.j.Mark(journal.Pending, "", fresh...)
+}
+
+// begin journals res as in flight before they are submitted.
+func (o *outbox) begin(res ...results.Result) error {
+	ents := make([]journal.Entry, len(res))
+	for i := range res {
+		ents[i] = entry(res[i])
+	}
+	return o.j.Mark(journal.InFlight, "", ents...)
+}
+
+// fail handles a submission that returned no answer.  If the request
+// never reached the server, or the server processed none of it, the
+// results are pending again.  Otherwise they stay in flight and are
+// reported as unconfirmed on the next pass.  A v5 error reply, such as
+// a busy server, means the result was not processed, as does a failed
+// login: the results are only posted once signed in.
+func (o *outbox) fail(err error, res ...results.Result) error {
+	var opErr *net.OpError
+	var pnErr *v5api.Error
+	notSent := errors.Is(err, primenet.ErrRejected) || errors.As(err, &pnErr) ||
+		errors.Is(err, primenet.ErrLogin) || errors.Is(err, primenet.ErrLoggedOut) ||
+		errors.As(err, &opErr) && opErr.Op == "dial"
+	if !notSent {
+		return nil
+	}
+	ents := make([]journal.Entry, len(res))
+	for i := range res {
+		ents[i] = entry(res[i])
+	}
+	return o.j.Mark(journal.Pending, err.Error(), ents...)
+}
+
+// record appends r to the sent file when accepted, or to the rejected file
+// followed by the reason, then journals the outcome and notes it in the
+// ledger.
+func (o *outbox) record(r results.Result, accepted bool, reason string, credit float64) error {
+	forget(o.st, []results.Result{r})
+	o.note(r, accepted, reason, credit)
+	if accepted {
+		if _, err := fmt.Fprintln(o.sent, r.Line); err != nil {
+			return fmt.Errorf("%s write error: %w", o.sent.Name(), err)
+		}
+		return o.j.Mark(journal.Acked, reason, entry(r))
+	}
+	log.Printf("Result for M%d refused (%s)", r.Exponent, reason)
+	if _, err := fmt.Fprintf(o.rejected, "%s\t# %s\n", r.Line, reason); err != nil {
+		return fmt.Errorf("%s write error: %w", o.rejected.Name(), err)
+	}
+	return o.j.Mark(journal.Rejected, reason, entry(r))
+}
+
+// note queues the outcome of r for the ledger.
+func (o *outbox) note(r results.Result, accepted bool, reason string, credit float64) {
+	o.answers = append(o.answers, answer{r: r, accepted: accepted, reason: reason, credit: credit, at: time.Now().UTC()})
+}
+
+// writeLedger adds the results seen and the answers to them to the ledger,
+// opening it once for the whole pass.
+func (o *outbox) writeLedger() {
+	if len(o.seen) == 0 && len(o.answers) == 0 {
+		return
+	}
+	o.m.useLedger(false, func(led *ledger.Ledger) error {
+		if err := led.Seen(o.dir, o.seen, o.seenAt); err != nil {
+			return err
+		}
+		for _, a := range o.answers {
+			outcome := ledger.Refused
+			if a.accepted {
+				outcome = ledger.Accepted
+			}
+			if err := led.Submitted(o.dir, a.r, outcome, a.reason, a.credit, a.at); err != nil {
+				return err
+			}
+			if a.r.Final() {
+				if err := led.Finish(o.dir, a.r.Exponent, a.at, false); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// unsent returns the results of res that must stay in results.txt.
+func (o *outbox) unsent(res []results.Result) []results.Result {
+	var keep []results.Result
+	for _, r := range res {
+		if s := o.j.State(journal.ID(r.Line)); !s.Final() {
+			keep = append(keep, r)
+		}
+	}
+	return keep
+}
+
+// compact drops the journal records of results no longer in results.txt,
+// given as its remaining lines.
+func (o *outbox) compact(lines [][]byte) error {
+	ids := make(map[string]bool, len(lines))
+	for _, l := range lines {
+		ids[journal.ID(string(l))] = true
+	}
+	return o.j.Compact(func(r journal.Record) bool { return ids[r.ID] })
+}
+
+func (o *outbox) Close() error {
+	o.writeLedger()
+	o.st.Close()
+	o.j.Close()
+	o.rejected.Close()
+	return o.sent.Close()
+}
+
+func entry(r results.Result) journal.Entry {
+	return journal.Entry{Exponent: r.Exponent, Line: r.Line}
+}
+
+// match pairs each submitted result with the server's record for it,
+// in order for repeated exponents.  Results the server did not report
+// on get an Unknown record.
+func match(batch []results.Result, recs []primenet.Record) []primenet.Record {
+	byExp := make(map[uint64][]primenet.Record)
+	for _, rec := range recs {
+		byExp[rec.Exponent] = append(byExp[rec.Exponent], rec)
+	}
+	out := make([]primenet.Record, len(batch))
+	for i, r := range batch {
+		q := byExp[r.Exponent]
+		if len(q) == 0 {
+			out[i] = primenet.Record{Exponent: r.Exponent, Detail: "not processed by the server"}
+			continue
+		}
+		out[i], byExp[r.Exponent] = q[0], q[1:]
+	}
+	return out
+}
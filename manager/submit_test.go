@@ -0,0 +1,89 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/Kunde21/MersenneManager/config"
+	"github.com/Kunde21/MersenneManager/fakeserver"
+	"github.com/Kunde21/MersenneManager/primenet"
+)
+
+// newPrimenet returns a manager using only the fake Primenet server, and
+// an mfakto device in a temporary directory.
+func newPrimenet(t *testing.T) (*Manager, *Device, *fakeserver.Primenet) {
+	t.Helper()
+	srv := fakeserver.NewPrimenet("user", "pass")
+	t.Cleanup(srv.Close)
+	m := &Manager{Primenet: primenet.New("user", "pass")}
+	m.Primenet.BaseURL = srv.BaseURL()
+	dev, err := NewDevice(Mfakto, config.Device{Workdir: t.TempDir(), Cache: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, dev, srv
+}
+
+// tfResult returns an mfakto no factor result line for exponent.
+func tfResult(exponent uint64) string {
+	return fmt.Sprintf("no factor for M%d from 2^74 to 2^75 [mfakto 0.15pre6-Win cl_barrett15_82_gs_2]", exponent)
+}
+
+// readFile returns the contents of the file at path, or "" if it can't
+// be read.
+func readFile(path string) string {
+	data, _ := ioutil.ReadFile(path)
+	return string(data)
+}
+
+func TestSendLoginFailure(t *testing.T) {
+	const loginForm = `<form><input type="password" name="user_password"></form>`
+	for _, test := range []struct {
+		name  string
+		setup func(m *Manager, srv *fakeserver.Primenet)
+		want  error
+	}{
+		{
+			name:  "bad password",
+			setup: func(m *Manager, srv *fakeserver.Primenet) { m.Primenet.Pass = "wrong" },
+			want:  primenet.ErrLogin,
+		},
+		{
+			name: "logged out after login",
+			setup: func(m *Manager, srv *fakeserver.Primenet) {
+				srv.Script(fakeserver.PrimenetResult, fakeserver.Reply(loginForm), fakeserver.Reply(loginForm))
+			},
+			want: primenet.ErrLoggedOut,
+		},
+	} {
+		m, dev, srv := newPrimenet(t)
+		line := tfResult(332197331) + "\n"
+		ioutil.WriteFile(dev.Files.Res, []byte(line), 0664)
+		test.setup(m, srv)
+		if err := m.SendResults(context.Background(), dev); !errors.Is(err, test.want) {
+			t.Errorf("%s: err = %v, want %v", test.name, err, test.want)
+		}
+		if got := readFile(dev.Files.Rejected); got != "" {
+			t.Errorf("%s: result quarantined: %q", test.name, got)
+		}
+
+		// Sent once the login works
+		m.Primenet.Pass = "pass"
+		if err := m.SendResults(context.Background(), dev); err != nil {
+			t.Errorf("%s: resend: %v", test.name, err)
+		}
+		if got := readFile(dev.Files.Sent); got != line {
+			t.Errorf("%s: sent file = %q", test.name, got)
+		}
+		if got := readFile(dev.Files.Res); got != "" {
+			t.Errorf("%s: results.txt after resending = %q", test.name, got)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package manager
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/Kunde21/MersenneManager/config"
+)
+
+// newTransport returns the transport shared by all of a manager's servers,
+// with the proxy and certificate authorities from sett.  Without a Proxy
+// setting the usual HTTP_PROXY, HTTPS_PROXY and NO_PROXY variables apply.
+func newTransport(sett *config.Settings) (*http.Transport, error) {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	if sett.Proxy != "" {
+		proxy, err := url.Parse(sett.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: %w", unwrapURL(err))
+		}
+		switch proxy.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("proxy %q: scheme must be http, https, socks5 or socks5h", proxy.Redacted())
+		}
+		tr.Proxy = http.ProxyURL(proxy)
+	}
+	if sett.CABundle != "" {
+		pem, err := ioutil.ReadFile(sett.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA bundle %s: no PEM certificates found", sett.CABundle)
+		}
+		tr.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return tr, nil
+}
+
+// endpoint parses the server address addr, or def when addr is empty.
+func endpoint(addr, def string) (*url.URL, error) {
+	if addr == "" {
+		addr = def
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("server address: %w", unwrapURL(err))
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("server address %q: need an http:// or https:// URL", u.Redacted())
+	}
+	return u, nil
+}
+
+// unwrapURL drops the address from a url.Parse error, since it may hold
+// a password.
+func unwrapURL(err error) error {
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		return uerr.Err
+	}
+	return err
+}
@@ -0,0 +1,185 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/Kunde21/MersenneManager/atomicfile"
+	"github.com/Kunde21/MersenneManager/config"
+	"github.com/Kunde21/MersenneManager/filelock"
+	"github.com/Kunde21/MersenneManager/ledger"
+	"github.com/Kunde21/MersenneManager/state"
+	"github.com/Kunde21/MersenneManager/worktodo"
+)
+
+// Unreserve returns the assignments of dev chosen by pick to the server they
+// came from and removes them from worktodo.txt.  pick is called with each
+// entry's position among the device's assignments.  When local is set the
+// entries are only removed from worktodo.txt.  Assignments that can't be
+// released are left in place.  The number removed is returned.
+func (m *Manager) Unreserve(ctx context.Context, dev *Device, local bool, pick func(i int, e *worktodo.Entry) bool) (int, error) {
+	if err := filelock.LockContext(ctx, dev.Files.Todo); err != nil {
+		return 0, fmt.Errorf("locking worktodo.txt: %w", err)
+	}
+	defer filelock.Unlock(dev.Files.Todo)
+	curr, err := ioutil.ReadFile(dev.Files.Todo)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	st, err := state.Open(ctx, dev.Files.Dir)
+	if err != nil {
+		return 0, err
+	}
+	defer st.Close()
+
+	wt := worktodo.Parse(curr)
+	var drop []*worktodo.Entry
+	var errs []error
+	for i, e := range wt.Entries(dev.workKinds()...) {
+		if !pick(i, e) {
+			continue
+		}
+		if !local {
+			if err := m.release(ctx, dev, st, e); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		if local {
+			log.Printf("Removed M%d from %s", e.Exponent, dev.Files.Dir)
+		} else {
+			log.Printf("Unreserved M%d from %s", e.Exponent, dev.Files.Dir)
+		}
+		drop = append(drop, e)
+		if a := st.Find(e.Exponent); a != nil {
+			st.Remove(a)
+		}
+	}
+	if len(drop) == 0 {
+		return 0, errors.Join(errs...)
+	}
+	now := time.Now().UTC()
+	m.useLedger(false, func(led *ledger.Ledger) error {
+		for _, e := range drop {
+			if err := led.Finish(dev.Files.Dir, e.Exponent, now, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	n := wt.Remove(drop...)
+	if err := atomicfile.WriteFile(dev.Files.Todo, wt.Bytes()); err != nil {
+		return 0, errors.Join(append(errs, err)...)
+	}
+	return n, errors.Join(append(errs, st.Save())...)
+}
+
+// errReleaseByHand is returned for assignments only the user can release.
+var errReleaseByHand = errors.New("GPU72 assignments must be released at gpu72.com")
+
+// release returns e to the server it was fetched from.
+func (m *Manager) release(ctx context.Context, dev *Device, st *state.State, e *worktodo.Entry) error {
+	if e.AID == "" || e.AID == "N/A" {
+		return nil // Not reserved, nothing to give back
+	}
+	a := st.Find(e.Exponent)
+	switch {
+	case a != nil && a.Source == state.SourceGPU72:
+		return fmt.Errorf("M%d: %w", e.Exponent, errReleaseByHand)
+	case a != nil && a.Source == state.SourceV5 && m.V5 != nil:
+		c, err := m.v5Client(ctx, dev, st)
+		if err != nil {
+			return err
+		}
+		return c.Unreserve(ctx, e.AID)
+	case m.Primenet != nil:
+		return m.Primenet.Unreserve(ctx, e.String())
+	}
+	return fmt.Errorf("M%d: no Primenet account to release the assignment", e.Exponent)
+}
+
+// ReleaseRemoved unreserves the work left in the directories of devices
+// that have been dropped from the settings since the last run.  Devices are
+// remembered in the known file; when release is false the file is only
+// updated.  Directories that still hold work after a failed release are
+// remembered for the next run, except when the only work left must be
+// released by hand: the user is told once and the device forgotten.
+func (m *Manager) ReleaseRemoved(ctx context.Context, known string, devs []*Device, release bool) error {
+	var prev []config.Device
+	data, err := ioutil.ReadFile(known)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &prev); err != nil {
+			return fmt.Errorf("%s: %w", known, err)
+		}
+	}
+
+	current := make(map[string]bool)
+	var keep []config.Device
+	for _, dev := range devs {
+		current[dev.Files.Dir] = true
+		cfg := dev.Device
+		cfg.Kind, cfg.Workdir = dev.Kind.String(), dev.Files.Dir
+		keep = append(keep, cfg)
+	}
+	var errs []error
+	for _, cfg := range prev {
+		if current[cfg.Workdir] || !release {
+			continue
+		}
+		kind, err := ParseKind(cfg.Kind)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		dev, err := NewDevice(kind, cfg)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		log.Println("Releasing work of removed device", cfg.Workdir)
+		_, err = m.Unreserve(ctx, dev, false, func(int, *worktodo.Entry) bool { return true })
+		switch {
+		case err == nil:
+		case byHand(err):
+			log.Printf("Forgetting removed device %s: %v", cfg.Workdir, err)
+		default:
+			errs = append(errs, err)
+			keep = append(keep, cfg)
+		}
+	}
+
+	data, err = json.MarshalIndent(keep, "", "  ")
+	if err != nil {
+		return err
+	}
+	return errors.Join(append(errs, atomicfile.WriteFile(known, append(data, '\n')))...)
+}
+
+// byHand reports whether every error joined in err is errReleaseByHand.
+func byHand(err error) bool {
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range j.Unwrap() {
+			if !byHand(e) {
+				return false
+			}
+		}
+		return true
+	}
+	return errors.Is(err, errReleaseByHand)
+}
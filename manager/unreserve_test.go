@@ -0,0 +1,78 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/Kunde21/MersenneManager/config"
+	"github.com/Kunde21/MersenneManager/state"
+	"github.com/Kunde21/MersenneManager/worktodo"
+)
+
+func TestReleaseRemoved(t *testing.T) {
+	const a, b = 332197331, 332197369
+	for _, test := range []struct {
+		name     string
+		reserved bool // the Primenet assignment is still held on the server
+		failed   bool // the release fails, so the device is remembered
+	}{
+		{name: "released", reserved: true},
+		{name: "release failed", failed: true},
+	} {
+		m, dev, pn := newPrimenet(t)
+		ctx := context.Background()
+		gone, err := NewDevice(Mfakto, config.Device{Workdir: t.TempDir()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		primenetWork, gpu72Work := factor(a), factor(b)
+		if test.reserved {
+			pn.Reserve(primenetWork)
+		}
+		writeTodo(t, gone, primenetWork, gpu72Work)
+		st, err := state.Open(ctx, gone.Files.Dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st.Add(&state.Assignment{Exponent: b, AID: gpu72Work.AID, Kind: worktodo.Factor, Source: state.SourceGPU72})
+		if err := st.Save(); err != nil {
+			t.Fatal(err)
+		}
+		st.Close()
+
+		known := filepath.Join(t.TempDir(), "TFdevices.json")
+		data, _ := json.Marshal([]config.Device{{Kind: gone.Kind.String(), Workdir: gone.Files.Dir}})
+		ioutil.WriteFile(known, data, 0664)
+
+		// A device left holding only GPU72 work is forgotten at once
+		for run := 0; run < 2; run++ {
+			if err := m.ReleaseRemoved(ctx, known, []*Device{dev}, true); (err != nil) != test.failed {
+				t.Errorf("%s: run %d: err = %v", test.name, run, err)
+			}
+		}
+		var prev []config.Device
+		if err := json.Unmarshal([]byte(readFile(known)), &prev); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if n := len(prev); n != 1 && !test.failed || n != 2 && test.failed {
+			t.Errorf("%s: devices file holds %d devices", test.name, n)
+		}
+		if got := len(pn.Reserved()); got != 0 {
+			t.Errorf("%s: %d assignments still reserved", test.name, got)
+		}
+		want := []uint64{b}
+		if test.failed {
+			want = []uint64{a, b}
+		}
+		if got := exponents(gone); len(got) != len(want) {
+			t.Errorf("%s: worktodo.txt holds %v, want %v", test.name, got, want)
+		}
+	}
+}
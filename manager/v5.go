@@ -0,0 +1,172 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/Kunde21/MersenneManager/primenet/v5api"
+	"github.com/Kunde21/MersenneManager/results"
+	"github.com/Kunde21/MersenneManager/state"
+	"github.com/Kunde21/MersenneManager/worktodo"
+)
+
+// v5Client returns a v5 client for the device, registering it with
+// PrimeNet the first time it is used.  The GUID is saved as soon as it is
+// made, and again once registered, so a pass that fetches no work doesn't
+// leave a computer on the account that the next pass registers again.
+func (m *Manager) v5Client(ctx context.Context, dev *Device, st *state.State) (*v5api.Client, error) {
+	if st.GUID == "" {
+		st.GUID = v5api.NewGUID()
+		st.Registered = false
+		if err := st.Save(); err != nil {
+			return nil, err
+		}
+	}
+	c := *m.V5
+	c.GUID = st.GUID
+	if st.Registered {
+		return &c, nil
+	}
+	host, _ := os.Hostname()
+	comp := v5api.Computer{
+		Name: fmt.Sprintf("%s-%v%d", host, dev.Kind, dev.Device.Device),
+		OS:   runtime.GOOS + "," + runtime.GOARCH,
+		CPU:  fmt.Sprintf("%v device %d", dev.Kind, dev.Device.Device),
+	}
+	if err := c.Register(ctx, comp); err != nil {
+		return nil, err
+	}
+	if err := c.SetWorkPreference(ctx, dev.Pref); err != nil {
+		return nil, err
+	}
+	st.Registered = true
+	if err := st.Save(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// getWorkV5 fetches up to n assignments through the v5 API.
+func (m *Manager) getWorkV5(ctx context.Context, n uint, dev *Device, st *state.State) (work []worktodo.Entry, err error) {
+	c, err := m.v5Client(ctx, dev, st)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint(0); i < n; i++ {
+		a, err := c.GetAssignment(ctx)
+		if unregistered(err) {
+			st.Registered = false
+			if c, err = m.v5Client(ctx, dev, st); err != nil {
+				return work, err
+			}
+			a, err = c.GetAssignment(ctx)
+		}
+		var pnErr *v5api.Error
+		if errors.As(err, &pnErr) && pnErr.Code == v5api.ErrorNoAssignment {
+			break
+		}
+		if err != nil {
+			return work, err
+		}
+		e, err := a.Entry()
+		if err != nil {
+			// Give back work this device can't run
+			log.Println(err)
+			if err := c.Unreserve(ctx, a.AID); err != nil {
+				log.Println(err)
+			}
+			continue
+		}
+		work = append(work, e)
+	}
+	return work, nil
+}
+
+// sendResultsV5 reports the results that have an assignment ID through the
+// v5 API, returning the results that still need to go to the manual page.
+// When the v5 server can't be reached the rest of send is left pending in
+// results.txt for the next pass, rather than sent to the manual page.
+func (m *Manager) sendResultsV5(ctx context.Context, dev *Device, out *outbox, send []results.Result) (manual []results.Result, err error) {
+	c, err := m.v5Client(ctx, dev, out.st)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range send {
+		aid := r.AID
+		if a := out.st.Find(r.Exponent); aid == "" && a != nil {
+			aid = a.AID
+		}
+		if aid == "" || aid == "N/A" {
+			manual = append(manual, r)
+			continue
+		}
+		if ctx.Err() != nil {
+			return manual, ctx.Err()
+		}
+		if err := out.begin(r); err != nil {
+			return manual, err
+		}
+		err := c.SendResult(context.WithoutCancel(ctx), aid, r)
+		if unregistered(err) {
+			out.st.Registered = false
+			nc, regErr := m.v5Client(ctx, dev, out.st)
+			if regErr != nil {
+				return manual, errors.Join(regErr, out.fail(err, r))
+			}
+			c = nc
+			err = c.SendResult(context.WithoutCancel(ctx), aid, r)
+		}
+		if err != nil && !refused(err) {
+			// Connection failure or busy server, try again next time
+			return manual, errors.Join(err, out.fail(err, r))
+		}
+		reason := "accepted"
+		if err != nil {
+			reason = err.Error()
+		}
+		if err := out.record(r, err == nil, reason, 0); err != nil {
+			return manual, err
+		}
+	}
+	return manual, nil
+}
+
+// unregistered reports whether err is PrimeNet asking the computer to
+// register again, because it has no record of the GUID or wants its
+// details updated.
+func unregistered(err error) bool {
+	var pnErr *v5api.Error
+	return errors.As(err, &pnErr) &&
+		(pnErr.Code == v5api.ErrorUnregisteredCPU || pnErr.Code == v5api.ErrorStaleCPUInfo)
+}
+
+// refused reports whether err is PrimeNet turning down a result, as
+// opposed to a failure that leaves the result to be sent again.
+func refused(err error) bool {
+	var pnErr *v5api.Error
+	if !errors.As(err, &pnErr) {
+		return false
+	}
+	return pnErr.Code != v5api.ErrorServerBusy && !unregistered(err)
+}
+
+// forget removes the state entries of completed assignments.
+func forget(st *state.State, sent []results.Result) {
+	for _, r := range sent {
+		if !r.Final() {
+			continue
+		}
+		if a := st.Find(r.Exponent); a != nil {
+			st.Finished(a, time.Now())
+		}
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kunde21/MersenneManager/config"
+	"github.com/Kunde21/MersenneManager/fakeserver"
+	"github.com/Kunde21/MersenneManager/primenet"
+	"github.com/Kunde21/MersenneManager/primenet/v5api"
+	"github.com/Kunde21/MersenneManager/state"
+	"github.com/Kunde21/MersenneManager/worktodo"
+)
+
+// newV5 returns a manager using only the fake v5 server, and a clLucas
+// double check device in a temporary directory.
+func newV5(t *testing.T) (*Manager, *Device, *fakeserver.V5) {
+	t.Helper()
+	srv := fakeserver.NewV5("user")
+	t.Cleanup(srv.Close)
+	m := &Manager{V5: v5api.New("user", "")}
+	m.V5.BaseURL = srv.BaseURL()
+	dev, err := NewDevice(ClLucas, config.Device{Workdir: t.TempDir(), WorkType: "101", Cache: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, dev, srv
+}
+
+// llResult returns a clLucas result line for exponent.
+func llResult(exponent uint64, aid string) string {
+	return fmt.Sprintf("M( %d )C, 0x1234abcd5678ef90, offset = 1234, n = 2560K, clLucas v1.04, AID: %s", exponent, aid)
+}
+
+func TestV5RegisterOnce(t *testing.T) {
+	m, dev, srv := newV5(t)
+	ctx := context.Background()
+	if err := m.Topoff(ctx, dev); !errors.Is(err, ErrNoWork) {
+		t.Fatalf("Topoff with no work: err = %v, want ErrNoWork", err)
+	}
+	st, err := state.Load(dev.Files.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.GUID == "" || !st.Registered {
+		t.Fatalf("state after registering = %+v", st)
+	}
+
+	srv.AddWork(101, worktodo.Entry{Kind: worktodo.DoubleCheck, Exponent: 45000017, BitLo: 72})
+	if err := m.Topoff(ctx, dev); err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.Hits(fakeserver.V5Register); n != 1 {
+		t.Errorf("registered %d times, want once", n)
+	}
+	if n := srv.Computers(); n != 1 {
+		t.Errorf("%d computers on the account, want 1", n)
+	}
+	if st2, _ := state.Load(dev.Files.Dir); st2.GUID != st.GUID {
+		t.Errorf("GUID changed from %s to %s", st.GUID, st2.GUID)
+	}
+}
+
+func TestV5TopoffAndSend(t *testing.T) {
+	m, dev, srv := newV5(t)
+	ctx := context.Background()
+	srv.AddWork(101,
+		worktodo.Entry{Kind: worktodo.DoubleCheck, Exponent: 45000017, BitLo: 72, PM1Done: true},
+		worktodo.Entry{Kind: worktodo.DoubleCheck, Exponent: 45000029, BitLo: 72, PM1Done: true},
+		worktodo.Entry{Kind: worktodo.DoubleCheck, Exponent: 45000047, BitLo: 72, PM1Done: true},
+	)
+	if err := m.Topoff(ctx, dev); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(dev.Files.Todo)
+	wt := worktodo.Parse(data)
+	work := wt.Entries(worktodo.DoubleCheck)
+	if len(work) != 2 {
+		t.Fatalf("worktodo.txt after Topoff:\n%s", data)
+	}
+	st, _ := state.Load(dev.Files.Dir)
+	for _, e := range work {
+		if a := st.Find(e.Exponent); a == nil || a.Source != state.SourceV5 || a.AID != e.AID {
+			t.Errorf("state of M%d = %+v", e.Exponent, a)
+		}
+	}
+
+	// The worker finished the first assignment
+	wt.Remove(wt.Find(work[0].Exponent))
+	ioutil.WriteFile(dev.Files.Todo, wt.Bytes(), 0664)
+	line := llResult(work[0].Exponent, work[0].AID)
+	ioutil.WriteFile(dev.Files.Res, []byte(line+"\n"), 0664)
+	if err := m.SendResults(ctx, dev); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.Results(); len(got) != 1 || got[0] != line {
+		t.Errorf("server results = %q", got)
+	}
+	if data, _ := ioutil.ReadFile(dev.Files.Res); len(data) != 0 {
+		t.Errorf("results.txt after sending: %q", data)
+	}
+	if data, _ := ioutil.ReadFile(dev.Files.Sent); !strings.Contains(string(data), line) {
+		t.Errorf("sent file: %q", data)
+	}
+	if st, _ := state.Load(dev.Files.Dir); st.Find(work[0].Exponent) != nil {
+		t.Error("finished assignment kept in the state file")
+	}
+}
+
+func TestV5SendErrors(t *testing.T) {
+	m, dev, srv := newV5(t)
+	ctx := context.Background()
+	srv.AddWork(101,
+		worktodo.Entry{Kind: worktodo.DoubleCheck, Exponent: 45000017, BitLo: 72},
+		worktodo.Entry{Kind: worktodo.DoubleCheck, Exponent: 45000029, BitLo: 72},
+	)
+	if err := m.Topoff(ctx, dev); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(dev.Files.Todo)
+	work := worktodo.Parse(data).Entries()
+	lines := []string{llResult(work[0].Exponent, work[0].AID), llResult(work[1].Exponent, work[1].AID)}
+	ioutil.WriteFile(dev.Files.Todo, nil, 0664)
+	ioutil.WriteFile(dev.Files.Res, []byte(strings.Join(lines, "\n")+"\n"), 0664)
+
+	// A busy server leaves the results to be sent next time
+	srv.Script(fakeserver.V5Result, fakeserver.V5Error(v5api.ErrorServerBusy))
+	if err := m.SendResults(ctx, dev); err == nil {
+		t.Error("busy server: no error")
+	}
+	if data, _ := ioutil.ReadFile(dev.Files.Res); strings.Count(string(data), "\n") != 2 {
+		t.Errorf("results.txt after a busy server:\n%s", data)
+	}
+
+	// Forgotten computers register again and resend
+	srv.Forget()
+	srv.Verdict(work[1].Exponent, v5api.ErrorWorkNoLongerNeeded)
+	if err := m.SendResults(ctx, dev); err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.Hits(fakeserver.V5Register); n != 2 {
+		t.Errorf("registered %d times, want 2", n)
+	}
+	if got := srv.Results(); len(got) != 1 || got[0] != lines[0] {
+		t.Errorf("server results = %q", got)
+	}
+	if data, _ := ioutil.ReadFile(dev.Files.Rejected); !strings.Contains(string(data), lines[1]) {
+		t.Errorf("refused result not in the rejected file: %q", data)
+	}
+	if data, _ := ioutil.ReadFile(dev.Files.Res); len(data) != 0 {
+		t.Errorf("results.txt after sending: %q", data)
+	}
+}
+
+func TestV5ConnectionFailure(t *testing.T) {
+	m, dev, srv := newV5(t)
+	pn := fakeserver.NewPrimenet("user", "pass")
+	defer pn.Close()
+	m.Primenet = primenet.New("user", "pass")
+	m.Primenet.BaseURL = pn.BaseURL()
+	ctx := context.Background()
+	srv.AddWork(101,
+		worktodo.Entry{Kind: worktodo.DoubleCheck, Exponent: 45000017, BitLo: 72},
+		worktodo.Entry{Kind: worktodo.DoubleCheck, Exponent: 45000029, BitLo: 72},
+	)
+	if err := m.Topoff(ctx, dev); err != nil {
+		t.Fatal(err)
+	}
+	work := worktodo.Parse([]byte(readFile(dev.Files.Todo))).Entries()
+	lines := []string{llResult(work[0].Exponent, work[0].AID), llResult(work[1].Exponent, work[1].AID)}
+	ioutil.WriteFile(dev.Files.Todo, nil, 0664)
+	ioutil.WriteFile(dev.Files.Res, []byte(strings.Join(lines, "\n")+"\n"), 0664)
+
+	m.V5.HTTP = &http.Client{Timeout: 100 * time.Millisecond}
+	srv.Script(fakeserver.V5Result, fakeserver.Hang)
+	if err := m.SendResults(ctx, dev); err == nil {
+		t.Error("timed out request: no error")
+	}
+	if n := pn.Hits(fakeserver.PrimenetResult); n != 0 {
+		t.Errorf("manual result page used %d times while the v5 server was down", n)
+	}
+	if got := readFile(dev.Files.Res); !strings.Contains(got, lines[1]) {
+		t.Errorf("unsent result not kept in results.txt: %q", got)
+	}
+
+	if err := m.SendResults(ctx, dev); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.Results(); len(got) != 1 || got[0] != lines[1] {
+		t.Errorf("v5 results = %q", got)
+	}
+	// The timed out submission may have reached the server
+	if got := readFile(dev.Files.Rejected); !strings.Contains(got, lines[0]+"\t# unconfirmed") {
+		t.Errorf("rejected file = %q", got)
+	}
+}
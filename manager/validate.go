@@ -0,0 +1,203 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/Kunde21/MersenneManager/config"
+	"github.com/Kunde21/MersenneManager/gpu72"
+	"github.com/Kunde21/MersenneManager/primenet"
+	"github.com/Kunde21/MersenneManager/primenet/v5api"
+)
+
+// KindOf returns the worker program for a configured device.
+type KindOf func(config.Device) (Kind, error)
+
+// Only is the KindOf for managers that run a single worker program.
+func Only(k Kind) KindOf {
+	return func(config.Device) (Kind, error) { return k, nil }
+}
+
+// DeviceKind is the KindOf for settings that name each device's Kind.
+func DeviceKind(d config.Device) (Kind, error) {
+	return ParseKind(d.Kind)
+}
+
+// Validate checks s and returns every problem found: values the manager
+// would replace, naming the value used instead, unusable directories and
+// files, and missing credentials for the devices' work sources.
+func Validate(s *config.Settings, kindOf KindOf) []error {
+	var errs []error
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if s.Polltime > config.MaxPoll {
+		add("Poll: %d hours is over the %d hour maximum, which is used instead", s.Polltime, config.MaxPoll)
+	}
+	if s.RetryMin != 0 && s.RetryMax != 0 && s.RetryMax < s.RetryMin {
+		add("MaxRetryMinutes: %d is below RetryMinutes %d", s.RetryMax, s.RetryMin)
+	}
+	if len(s.Devices) == 0 {
+		add("Devices: no devices configured")
+	}
+
+	dirs := make(map[string]string)
+	names := make(map[string]string)
+	needPrimenet, needAccount := false, false
+	for i, d := range s.Devices {
+		dev := fmt.Sprintf("device %d", i)
+		if d.Name != "" {
+			dev = fmt.Sprintf("device %d (%s)", i, d.Name)
+			if other, ok := names[d.Name]; ok {
+				add("%s: Name is also used by %s", dev, other)
+			}
+			names[d.Name] = dev
+		}
+
+		kind, err := kindOf(d)
+		if err != nil {
+			add("%s: Kind: %v", dev, err)
+		}
+		switch {
+		case err != nil:
+		case kind == Mfakto:
+			needAccount = true
+			if d.WorkType != "lltf" && d.WorkType != "dctf" {
+				add("%s: WorkType %q: use lltf or dctf (lltf is used instead)", dev, d.WorkType)
+			}
+			if _, ok := WorkOptions[d.WorkOption]; !ok && d.WorkOption != "" {
+				add("%s: WorkOption %q: use one of %v (what_makes_sense is used instead)", dev, d.WorkOption, workOptions())
+			}
+			if d.Target != 0 && d.Target < MinTarget {
+				add("%s: TargetExponent %d is below the minimum of %d, which is used instead", dev, d.Target, MinTarget)
+			}
+		case kind == ClLucas:
+			needPrimenet = true
+			switch pref, _ := strconv.ParseUint(d.WorkType, 10, 0); pref {
+			case primenet.FirstLL, primenet.DoubleCheck, primenet.WorldRecordLL:
+			default:
+				add("%s: WorkType %q: use %d (first-time LL), %d (double check) or %d (world record LL)",
+					dev, d.WorkType, primenet.FirstLL, primenet.DoubleCheck, primenet.WorldRecordLL)
+			}
+			for _, f := range d.FFTSmooth {
+				if f != 2 && f != 3 && f != 5 && f != 7 {
+					add("%s: FFTSmooth %d: use 2, 3, 5 or 7", dev, f)
+				}
+			}
+		}
+
+		dir, err := filepath.Abs(d.Workdir)
+		if err != nil {
+			add("%s: Directory %q: %v", dev, d.Workdir, err)
+			continue
+		}
+		if other, ok := dirs[dir]; ok {
+			add("%s: Directory %s is also used by %s", dev, dir, other)
+		}
+		dirs[dir] = dev
+		if fi, err := os.Stat(dir); err != nil {
+			add("%s: Directory: %v", dev, err)
+		} else if !fi.IsDir() {
+			add("%s: Directory %s is not a directory", dev, dir)
+		} else if err := writableDir(dir); err != nil {
+			add("%s: Directory %s is not writable: %v", dev, dir, err)
+		}
+		if exe := execPath(dir, d.Exec); exe != "" {
+			if _, err := exec.LookPath(exe); err != nil {
+				add("%s: Executable: %v", dev, err)
+			}
+		}
+	}
+
+	switch {
+	case needPrimenet && !s.Primenet():
+		add("clLucas devices need a Primenet account: set UserName and Password, or %s and %s", config.EnvUser, config.EnvPass)
+	case needAccount && !s.Primenet() && !s.GPU72():
+		add("no Primenet or GPU72 account: set UserName and Password or GPU72UserName and GPU72Password, or their environment variables")
+	}
+	if (s.Usrname == "") != (s.Pass == "") {
+		add("UserName and Password must be set together")
+	}
+	if (s.GPU72Usr == "") != (s.GPU72Pass == "") {
+		add("GPU72UserName and GPU72Password must be set together")
+	}
+	if s.V5 && s.Usrname == "" {
+		add("PrimenetV5: needs UserName")
+	}
+
+	for _, u := range []struct{ key, addr, def string }{
+		{"PrimenetURL", s.PrimenetURL, primenet.DefaultURL},
+		{"GPU72URL", s.GPU72URL, gpu72.DefaultURL},
+		{"PrimenetV5URL", s.V5URL, v5api.DefaultURL},
+	} {
+		if _, err := endpoint(u.addr, u.def); err != nil {
+			add("%s: %v", u.key, err)
+		}
+	}
+	if _, err := newTransport(s); err != nil {
+		add("%v", err)
+	}
+	for _, f := range []struct{ key, path string }{
+		{"Logs", s.LogFile},
+		{"Ledger", s.Ledger},
+		{"CookieFile", s.Cookies},
+	} {
+		if f.path == "" {
+			continue
+		}
+		if err := writable(f.path); err != nil {
+			add("%s: %s is not writable: %v", f.key, f.path, err)
+		}
+	}
+	return errs
+}
+
+func workOptions() []string {
+	var opts []string
+	for o := range WorkOptions {
+		opts = append(opts, o)
+	}
+	sort.Strings(opts)
+	return opts
+}
+
+// writable checks that the file at path can be opened for writing, or
+// created when it doesn't exist, without changing it.
+func writable(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if os.IsNotExist(err) {
+		return writableDir(filepath.Dir(path))
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// writableDir checks that files can be created in dir.
+func writableDir(dir string) error {
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, ".validate")
+	if err != nil {
+		var perr *os.PathError
+		if errors.As(err, &perr) {
+			return perr.Err // not the temporary file's name
+		}
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
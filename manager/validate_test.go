@@ -0,0 +1,48 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package manager
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kunde21/MersenneManager/config"
+)
+
+func TestValidate(t *testing.T) {
+	good := config.Device{Workdir: t.TempDir(), WorkType: "dctf", WorkOption: "lowest_exponent", Target: 74}
+	s := config.Settings{Usrname: "user", Pass: "pass", Polltime: 6, Devices: []config.Device{good}}
+	if problems := Validate(&s, Only(Mfakto)); len(problems) > 0 {
+		t.Errorf("good settings: %v", problems)
+	}
+
+	// Started with -force, the reported values are replaced
+	s.Polltime = 500
+	s.Devices = []config.Device{{Workdir: t.TempDir(), WorkType: "lltx", WorkOption: "fastest", Target: 70}}
+	problems := fmt.Sprint(Validate(&s, Only(Mfakto)))
+	for _, want := range []string{
+		"Poll: 500 hours is over the 120 hour maximum, which is used instead",
+		`WorkType "lltx": use lltf or dctf (lltf is used instead)`,
+		`WorkOption "fastest": use one of`,
+		"TargetExponent 70 is below the minimum of 73, which is used instead",
+	} {
+		if !strings.Contains(problems, want) {
+			t.Errorf("problems don't include %q:\n%s", want, problems)
+		}
+	}
+	st, err := NewSetup(&s, Only(Mfakto))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Poll != config.MaxPoll*time.Hour || s.Polltime != 500 {
+		t.Errorf("Poll %v, Polltime %d", st.Poll, s.Polltime)
+	}
+	dev := st.Devices[0]
+	if dev.WorkType != "lltf" || dev.GPU72Opt != WorkOptions["what_makes_sense"] || dev.Target != MinTarget {
+		t.Errorf("device used WorkType %q, option %d, target %d", dev.WorkType, dev.GPU72Opt, dev.Target)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+
+	"github.com/Kunde21/MersenneManager/fft"
+	"github.com/Kunde21/MersenneManager/state"
+	"github.com/Kunde21/MersenneManager/worker"
+	"github.com/Kunde21/MersenneManager/worktodo"
+)
+
+// Worker returns a supervisor for the device's worker program, or nil
+// when no executable is configured.
+func (dev *Device) Worker() *worker.Worker {
+	if dev.Files.Exec == "" {
+		return nil
+	}
+	return &worker.Worker{
+		Name:     fmt.Sprintf("%v%d", dev.Kind, dev.Device.Device),
+		Path:     dev.Files.Exec,
+		ArgsFunc: dev.workerArgs,
+		Output:   dev.prog.observe,
+		Dir:      dev.Files.Dir,
+	}
+}
+
+// workerArgs builds the command line for the next start of the worker,
+// including the FFT length chosen for the assignments in worktodo.txt.
+func (dev *Device) workerArgs() []string {
+	args := []string{"-d", fmt.Sprint(dev.Device.Device)}
+	if dev.Kind == ClLucas && dev.GpuTh > 0 {
+		args = append(args, "-threads", fmt.Sprint(dev.GpuTh))
+	}
+	if n := dev.nextFFT(); n > 0 {
+		args = append(args, "-f", fft.Format(n))
+	}
+	return append(args, dev.Args...)
+}
+
+// nextFFT returns the FFT length for the first clLucas assignment in
+// worktodo.txt, preferring the length recorded when it was fetched.
+// clLucas keeps one -f for every assignment it runs, so 0 is returned,
+// leaving clLucas to choose, when a later assignment doesn't fit it.
+func (dev *Device) nextFFT() int {
+	if dev.Kind != ClLucas || len(dev.FFTSmooth) == 0 {
+		return 0
+	}
+	data, err := ioutil.ReadFile(dev.Files.Todo)
+	if err != nil {
+		return 0
+	}
+	ents := worktodo.Parse(data).Entries(dev.workKinds()...)
+	if len(ents) == 0 {
+		return 0
+	}
+	n := dev.fftLength(ents[0].Exponent)
+	if st, err := state.Load(dev.Files.Dir); err == nil {
+		if a := st.Find(ents[0].Exponent); a != nil && a.FFT > 0 {
+			n = a.FFT
+		}
+	}
+	for _, e := range ents[1:] {
+		if !fft.Fits(e.Exponent, n) {
+			return 0
+		}
+	}
+	return n
+}
+
+// fftLength selects the FFT length for exponent from the device's
+// allowed sizes, or 0 if the device doesn't restrict them.
+func (dev *Device) fftLength(exponent uint64) int {
+	if dev.Kind != ClLucas || len(dev.FFTSmooth) == 0 || exponent == 0 {
+		return 0
+	}
+	n, err := fft.Select(exponent, dev.FFTSmooth)
+	if err != nil {
+		log.Println(err)
+		return 0
+	}
+	return n
+}
+
+// StartWorkers runs the worker program of each device that has one.
+// The workers are stopped when ctx is cancelled; wait blocks until
+// they have all exited.
+func StartWorkers(ctx context.Context, devs []*Device) (wait func()) {
+	var wg sync.WaitGroup
+	for _, dev := range devs {
+		w := dev.Worker()
+		if w == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.Run(ctx); err != nil && err != ctx.Err() {
+				log.Printf("%s: %v", w.Name, err)
+			}
+		}()
+	}
+	return wg.Wait
+}
@@ -0,0 +1,58 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package manager
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Kunde21/MersenneManager/config"
+	"github.com/Kunde21/MersenneManager/fft"
+	"github.com/Kunde21/MersenneManager/state"
+	"github.com/Kunde21/MersenneManager/worktodo"
+)
+
+func TestWorkerArgs(t *testing.T) {
+	table := fft.Table([]uint{2})
+	small, large := fft.MaxExponent(table[3]), fft.MaxExponent(table[4])
+	smallFFT, largeFFT := fft.Format(table[3]), fft.Format(table[4])
+	test := func(exp uint64) worktodo.Entry {
+		return worktodo.Entry{Kind: worktodo.Test, Exponent: exp, BitLo: 74}
+	}
+
+	for _, tc := range []struct {
+		name  string
+		work  []worktodo.Entry
+		saved int // FFT length recorded for the first assignment
+		want  string
+	}{
+		{name: "empty", want: "-d 1 -threads 256"},
+		{name: "one", work: []worktodo.Entry{test(small)}, want: "-d 1 -threads 256 -f " + smallFFT},
+		{name: "smaller after", work: []worktodo.Entry{test(large), test(small)}, want: "-d 1 -threads 256 -f " + largeFFT},
+		{name: "larger after", work: []worktodo.Entry{test(small), test(large)}, want: "-d 1 -threads 256"},
+		{name: "saved", work: []worktodo.Entry{test(small), test(large)}, saved: table[4], want: "-d 1 -threads 256 -f " + largeFFT},
+	} {
+		dev, err := NewDevice(ClLucas, config.Device{Workdir: t.TempDir(), Device: 1, WorkType: "100", GpuTh: 256, FFTSmooth: []uint{2}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeTodo(t, dev, tc.work...)
+		if tc.saved > 0 {
+			st, err := state.Open(context.Background(), dev.Files.Dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			st.Add(&state.Assignment{Exponent: tc.work[0].Exponent, FFT: tc.saved})
+			if err := st.Save(); err != nil {
+				t.Fatal(err)
+			}
+			st.Close()
+		}
+		if got := strings.Join(dev.workerArgs(), " "); got != tc.want {
+			t.Errorf("%s: args = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
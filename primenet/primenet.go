@@ -0,0 +1,225 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+// Package primenet is a client for the mersenne.org manual assignment
+// and manual result pages.
+package primenet
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Kunde21/MersenneManager/worktodo"
+)
+
+// DefaultURL is the Primenet server address.
+const DefaultURL = "https://www.mersenne.org/"
+
+// Work preference codes for the manual assignment page.
+const (
+	TrialFactoring = 2
+	FirstLL        = 100
+	DoubleCheck    = 101
+	WorldRecordLL  = 102
+)
+
+// UnreservePath is the manual unreserve page.  It takes assignment lines
+// in the same "data" field as the manual result page.
+var UnreservePath = "/manual_assignment/unreserve/"
+
+var (
+	// ErrLogin is returned when Primenet does not accept the credentials.
+	ErrLogin = errors.New("primenet: login failed")
+	// ErrRejected is returned when Primenet does not process a result batch.
+	ErrRejected = errors.New("primenet: results not processed")
+	// ErrLoggedOut is returned when a page still shows the login form
+	// after signing in again.
+	ErrLoggedOut = errors.New("primenet: session expired")
+)
+
+// Client holds a Primenet session.
+type Client struct {
+	BaseURL    *url.URL
+	User, Pass string
+	HTTP       *http.Client
+}
+
+// New returns a client for the default Primenet server.
+func New(user, pass string) *Client {
+	base, _ := url.Parse(DefaultURL)
+	jar, _ := cookiejar.New(nil) // cookiejar.New() doesn't have an error return path
+	return &Client{
+		BaseURL: base,
+		User:    user,
+		Pass:    pass,
+		HTTP:    &http.Client{Jar: jar, Timeout: 30 * time.Second},
+	}
+}
+
+// Login posts the account credentials, storing the session cookie in the client.
+func (c *Client) Login(ctx context.Context) error {
+	login := url.Values{}
+	login.Set("user_login", c.User)
+	login.Set("user_password", c.Pass)
+
+	body, _, err := c.roundTrip(ctx, http.MethodPost, c.BaseURL.String(), login)
+	if err != nil {
+		return fmt.Errorf("primenet login: %w", err)
+	}
+	if !bytes.Contains(body, []byte(c.User+`<br>logged in`)) {
+		return ErrLogin
+	}
+	return nil
+}
+
+// GetWork requests n assignments of the given work preference and returns the
+// worktodo entries found in the response.
+func (c *Client) GetWork(ctx context.Context, n, pref uint) ([]worktodo.Entry, error) {
+	asgnURL, err := c.BaseURL.Parse("/manual_assignment/")
+	if err != nil {
+		return nil, err
+	}
+	reqV := asgnURL.Query()
+	reqV.Set("cores", "1")
+	reqV.Set("num_to_get", fmt.Sprint(n))
+	reqV.Set("pref", fmt.Sprint(pref))
+	reqV.Set("exp_lo", "")
+	reqV.Set("exp_hi", "")
+	reqV.Set("B1", "Get Assignments")
+	asgnURL.RawQuery = reqV.Encode()
+
+	body, _, err := c.send(ctx, http.MethodGet, asgnURL.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("primenet getwork: %w", err)
+	}
+	return worktodo.Scan(body), nil
+}
+
+// SendBatch submits a newline separated batch of result lines and returns
+// the server's report on each line it processed.  ErrRejected is returned
+// when the response doesn't process any lines.
+func (c *Client) SendBatch(ctx context.Context, batch []byte) ([]Record, error) {
+	sendURL, err := c.BaseURL.Parse("/manual_result/default.php")
+	if err != nil {
+		return nil, err
+	}
+	reqV := sendURL.Query()
+	reqV.Set("data", string(batch))
+	reqV.Set("B1", "Submit")
+
+	body, _, err := c.send(ctx, http.MethodPost, sendURL.String(), reqV)
+	if err != nil {
+		return nil, fmt.Errorf("primenet sendbatch: %w", err)
+	}
+	if !Processed(body) {
+		return nil, ErrRejected
+	}
+	return ParseResponse(body), nil
+}
+
+// Unreserve returns an assignment, given as its worktodo line, to Primenet.
+func (c *Client) Unreserve(ctx context.Context, line string) error {
+	unURL, err := c.BaseURL.Parse(UnreservePath)
+	if err != nil {
+		return err
+	}
+	reqV := unURL.Query()
+	reqV.Set("data", line)
+	reqV.Set("B1", "Unreserve")
+
+	body, _, err := c.send(ctx, http.MethodPost, unURL.String(), reqV)
+	if err != nil {
+		return fmt.Errorf("primenet unreserve: %w", err)
+	}
+	if !bytes.Contains(bytes.ToLower(body), []byte("unreserved")) {
+		return fmt.Errorf("primenet unreserve: not confirmed for %s", line)
+	}
+	return nil
+}
+
+// Assignments lists the assignments reserved to the account, from the
+// workload page.
+func (c *Client) Assignments(ctx context.Context) ([]worktodo.Entry, error) {
+	wlURL, err := c.BaseURL.Parse("/workload/")
+	if err != nil {
+		return nil, err
+	}
+	body, status, err := c.send(ctx, http.MethodGet, wlURL.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("primenet workload: %w", err)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("primenet workload: %d %s", status, http.StatusText(status))
+	}
+	// Prefer worktodo lines when the page has them, they carry bit levels
+	if ents := worktodo.Scan(body); len(ents) > 0 {
+		return ents, nil
+	}
+	return worktodo.ScanRows(body), nil
+}
+
+// HasSession reports whether the client holds a session cookie for the
+// server, from an earlier Login or a saved cookie jar.  The session may
+// have expired since.
+func (c *Client) HasSession() bool {
+	return c.HTTP.Jar != nil && len(c.HTTP.Jar.Cookies(c.BaseURL)) > 0
+}
+
+// LoggedOut reports whether body is a page offering the login form, which
+// Primenet shows in place of account pages once the session has expired.
+func LoggedOut(body []byte) bool {
+	return bytes.Contains(body, []byte(`name="user_password"`))
+}
+
+// send makes a request and returns the response body and status code.  If
+// the reply shows the session has expired, send signs in again and repeats
+// the request once.
+func (c *Client) send(ctx context.Context, method, u string, form url.Values) ([]byte, int, error) {
+	body, status, err := c.roundTrip(ctx, method, u, form)
+	if err != nil || !LoggedOut(body) {
+		return body, status, err
+	}
+	if err := c.Login(ctx); err != nil {
+		return nil, 0, err
+	}
+	body, status, err = c.roundTrip(ctx, method, u, form)
+	if err == nil && LoggedOut(body) {
+		err = ErrLoggedOut
+	}
+	return body, status, err
+}
+
+// roundTrip makes one request, sending form as the body of a POST.
+func (c *Client) roundTrip(ctx context.Context, method, u string, form url.Values) ([]byte, int, error) {
+	var rd io.Reader
+	if form != nil {
+		rd = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, rd)
+	if err != nil {
+		return nil, 0, err
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("reading response: %w", err)
+	}
+	return body, resp.StatusCode, nil
+}
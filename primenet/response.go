@@ -0,0 +1,124 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package primenet
+
+import (
+	"bufio"
+	"bytes"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Status is the outcome Primenet reported for a submitted result line.
+type Status int
+
+// Submission outcomes.
+const (
+	Unknown      Status = iota // processed without a recognized outcome
+	Accepted                   // stored and credited
+	Duplicate                  // already in the database
+	NoAssignment               // no matching assignment, and not credited
+	Rejected                   // refused with an error
+)
+
+func (s Status) String() string {
+	switch s {
+	case Accepted:
+		return "accepted"
+	case Duplicate:
+		return "duplicate"
+	case NoAssignment:
+		return "no assignment"
+	case Rejected:
+		return "rejected"
+	}
+	return "unknown"
+}
+
+// Record is the server's report on one processed result line.
+type Record struct {
+	Exponent uint64
+	Text     string // the "processing:" description
+	Status   Status
+	Detail   string  // error or warning message
+	Credit   float64 // GHz-days
+}
+
+var (
+	brReg       = regexp.MustCompile(`(?i)<br\s*/?>|</?(p|div|pre|li|tr)\b[^>]*>`)
+	tagReg      = regexp.MustCompile(`<[^>]*>`)
+	expReg      = regexp.MustCompile(`M\(? ?([0-9]+)`)
+	creditReg   = regexp.MustCompile(`(?i)credit is ([0-9.]+)`)
+	duplicateRe = regexp.MustCompile(`(?i)already (in the database|been (submitted|reported|sent))|duplicate`)
+	noAsgnRe    = regexp.MustCompile(`(?i)no assignment|error code: 40\b`)
+	errorRe     = regexp.MustCompile(`(?i)\berror\b`)
+	summaryRe   = regexp.MustCompile(`(?i)^(found|did not understand|recognized, but ignored|skipped|accepted|done processing)\b.*\blines?\b`)
+)
+
+// ParseResponse splits a manual_result response into one record per
+// "processing:" line, classified by the messages that follow it.
+func ParseResponse(body []byte) []Record {
+	text := brReg.ReplaceAllString(string(body), "\n")
+	text = html.UnescapeString(tagReg.ReplaceAllString(text, ""))
+	var recs []Record
+	var cur *Record
+	sc := bufio.NewScanner(strings.NewReader(text))
+	sc.Buffer(nil, len(body)+1)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "processing:") {
+			recs = append(recs, Record{Text: strings.TrimSpace(strings.TrimPrefix(line, "processing:"))})
+			cur = &recs[len(recs)-1]
+			if m := expReg.FindStringSubmatch(cur.Text); m != nil {
+				cur.Exponent, _ = strconv.ParseUint(m[1], 10, 64)
+			}
+			continue
+		}
+		if summaryRe.MatchString(line) {
+			cur = nil // end of the per-line messages
+		}
+		if cur == nil {
+			continue
+		}
+		cur.classify(line)
+	}
+	for i := range recs {
+		if recs[i].Status == Unknown && recs[i].Detail == "" {
+			// Processed without complaint
+			recs[i].Status = Accepted
+		}
+	}
+	return recs
+}
+
+// classify applies a message line that follows a "processing:" line.
+func (r *Record) classify(line string) {
+	switch {
+	case creditReg.MatchString(line):
+		r.Credit, _ = strconv.ParseFloat(creditReg.FindStringSubmatch(line)[1], 64)
+		r.Status = Accepted
+	case duplicateRe.MatchString(line):
+		r.Status, r.Detail = Duplicate, line
+	case noAsgnRe.MatchString(line):
+		if r.Status != Accepted {
+			r.Status = NoAssignment
+		}
+		r.Detail = line
+	case errorRe.MatchString(line):
+		r.Status, r.Detail = Rejected, line
+	}
+}
+
+// Processed reports whether body is a manual_result response that
+// processed at least one line.  The "Done processing:" summary is shown
+// even when no line was processed, so it doesn't count.
+func Processed(body []byte) bool {
+	return bytes.Contains(body, []byte("processing:")) && len(ParseResponse(body)) > 0
+}
@@ -0,0 +1,54 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package primenet
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseResponse(t *testing.T) {
+	for _, test := range []struct {
+		file string
+		want []Record
+	}{
+		{
+			file: "accepted.html",
+			want: []Record{
+				{Exponent: 332197331, Text: "TF no factor for M332197331 from 2^74 to 2^75", Status: Accepted, Credit: 30.2567},
+				{Exponent: 45000017, Text: "LL result for M45000017", Status: Accepted, Credit: 78.0041},
+			},
+		},
+		{
+			file: "mixed.html",
+			want: []Record{
+				{Exponent: 332197333, Text: "TF no factor for M332197333 from 2^74 to 2^75",
+					Status: Duplicate, Detail: "Result already in the database."},
+				{Exponent: 332197357, Text: "TF factor found for M332197357: 38814612911305349835664385407",
+					Status: Accepted, Detail: "Error code: 40, error string: No assignment", Credit: 2.1},
+				{Exponent: 45000029, Text: "LL result for M45000029",
+					Status: NoAssignment, Detail: "Error code: 40, error string: No assignment"},
+				{Exponent: 45000047, Text: "LL result for M45000047",
+					Status: Rejected, Detail: `Error: residue "0x00000000" is invalid`},
+				// The summary is not a message about the last line
+				{Exponent: 332197381, Text: "TF no factor for M332197381 from 2^74 to 2^75", Status: Accepted},
+			},
+		},
+		{file: "none.html"},
+	} {
+		body, err := ioutil.ReadFile(filepath.Join("testdata", test.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ParseResponse(body); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", test.file, got, test.want)
+		}
+		if got := Processed(body); got != (len(test.want) > 0) {
+			t.Errorf("%s: Processed = %v", test.file, got)
+		}
+	}
+}
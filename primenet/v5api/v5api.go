@@ -0,0 +1,319 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+// Package v5api is a client for the PrimeNet v5 automated client protocol,
+// the server API used by Prime95 to register computers, fetch assignments
+// and report progress and results.
+package v5api
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kunde21/MersenneManager/results"
+	"github.com/Kunde21/MersenneManager/worktodo"
+)
+
+// DefaultURL is the PrimeNet v5 server address.
+const DefaultURL = "https://v5.mersenne.org/v5server/"
+
+// ProtocolVersion is the protocol version sent with every request.
+const ProtocolVersion = "0.95"
+
+// PrimeNet work type codes.
+const (
+	WorkFactor      = 2
+	WorkPfactor     = 4
+	WorkECM         = 5
+	WorkFirstLL     = 100
+	WorkDoubleCheck = 101
+	WorkWorldRecord = 102
+	WorkPRP         = 150
+)
+
+// PrimeNet result type codes used by ar.
+const (
+	ResultTFFactor   = 1
+	ResultTFNoFactor = 4
+	ResultLL         = 100
+	ResultLLPrime    = 101
+)
+
+// PrimeNet error codes.
+const (
+	ErrorOK                 = 0
+	ErrorServerBusy         = 3
+	ErrorInvalidParameter   = 7
+	ErrorInvalidUser        = 21
+	ErrorUnregisteredCPU    = 30
+	ErrorStaleCPUInfo       = 32
+	ErrorNoAssignment       = 40
+	ErrorInvalidAssignment  = 43
+	ErrorWorkNoLongerNeeded = 47
+)
+
+// Error is a non-zero pnErrorResult returned by the server.
+type Error struct {
+	Code   int
+	Detail string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("primenet v5 error %d: %s", e.Code, e.Detail)
+}
+
+// Client makes v5 requests on behalf of a single registered computer.
+type Client struct {
+	BaseURL *url.URL
+	HTTP    *http.Client
+	User    string // PrimeNet user ID
+	GUID    string // computer GUID, see NewGUID
+}
+
+// New returns a client for the default v5 server.
+func New(user, guid string) *Client {
+	base, _ := url.Parse(DefaultURL)
+	return &Client{
+		BaseURL: base,
+		HTTP:    &http.Client{Timeout: 30 * time.Second},
+		User:    user,
+		GUID:    guid,
+	}
+}
+
+// NewGUID returns a random 32 hex digit GUID for computer registration.
+func NewGUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err) // crypto/rand does not fail on supported platforms
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// Response holds the key/value pairs of a server reply.
+type Response map[string]string
+
+// Computer describes the machine sent with computer registration.
+type Computer struct {
+	Name     string // computer name shown on the PrimeNet account
+	Hardware string // hardware GUID, defaults to the computer GUID
+	OS       string // operating system / application string
+	CPU      string // processor or GPU description
+	Speed    int    // MHz
+	Memory   int    // MB
+	Hours    int    // hours per day the computer runs
+}
+
+// Register sends the computer's details to PrimeNet (uc).
+func (c *Client) Register(ctx context.Context, comp Computer) error {
+	if comp.Hardware == "" {
+		comp.Hardware = c.GUID
+	}
+	if comp.Hours == 0 {
+		comp.Hours = 24
+	}
+	v := url.Values{}
+	v.Set("hg", comp.Hardware)
+	v.Set("wg", "")
+	v.Set("a", comp.OS)
+	v.Set("c", comp.CPU)
+	v.Set("f", "")
+	v.Set("L1", "0")
+	v.Set("L2", "0")
+	v.Set("np", "1")
+	v.Set("hp", "0")
+	v.Set("m", fmt.Sprint(comp.Memory))
+	v.Set("s", fmt.Sprint(comp.Speed))
+	v.Set("h", fmt.Sprint(comp.Hours))
+	v.Set("r", "0")
+	v.Set("u", c.User)
+	v.Set("cn", comp.Name)
+	_, err := c.do(ctx, "uc", v)
+	return err
+}
+
+// SetWorkPreference sets the work type handed out by GetAssignment (po).
+func (c *Client) SetWorkPreference(ctx context.Context, pref uint) error {
+	v := url.Values{}
+	v.Set("w", fmt.Sprint(pref))
+	v.Set("nw", "1")
+	_, err := c.do(ctx, "po", v)
+	return err
+}
+
+// Assignment is a work unit handed out by PrimeNet.
+type Assignment struct {
+	AID      string
+	WorkType int
+	Exponent uint64
+	BitLo    uint // trial factoring start bit level
+	BitHi    uint // trial factoring end bit level
+	PM1Done  bool
+	Response Response // every field of the ga reply
+}
+
+// Entry returns the worktodo.txt entry for the assignment.
+func (a Assignment) Entry() (worktodo.Entry, error) {
+	e := worktodo.Entry{AID: a.AID, Exponent: a.Exponent, BitLo: a.BitLo, PM1Done: a.PM1Done}
+	switch a.WorkType {
+	case WorkFactor:
+		e.Kind, e.BitHi = worktodo.Factor, a.BitHi
+	case WorkFirstLL, WorkWorldRecord:
+		e.Kind = worktodo.Test
+	case WorkDoubleCheck:
+		e.Kind = worktodo.DoubleCheck
+	default:
+		return e, fmt.Errorf("v5api: unsupported work type %d for M%d", a.WorkType, a.Exponent)
+	}
+	return e, nil
+}
+
+// GetAssignment fetches one assignment of the computer's work preference (ga).
+func (c *Client) GetAssignment(ctx context.Context) (Assignment, error) {
+	v := url.Values{}
+	v.Set("c", "0")
+	v.Set("a", "")
+	resp, err := c.do(ctx, "ga", v)
+	if err != nil {
+		return Assignment{}, err
+	}
+	a := Assignment{AID: resp["k"], Response: resp}
+	a.WorkType, _ = strconv.Atoi(resp["w"])
+	a.Exponent, _ = strconv.ParseUint(resp["n"], 10, 64)
+	sf, _ := strconv.ParseUint(resp["sf"], 10, 0)
+	ef, _ := strconv.ParseUint(resp["ef"], 10, 0)
+	a.BitLo, a.BitHi = uint(sf), uint(ef)
+	a.PM1Done = resp["p1"] == "1"
+	if a.AID == "" || a.Exponent == 0 {
+		return a, fmt.Errorf("v5api: incomplete assignment: %v", resp)
+	}
+	return a, nil
+}
+
+// Progress reports the state of a running assignment.
+type Progress struct {
+	Stage    string        // LL, TF...
+	Percent  float64       // 0-100
+	Next     time.Duration // time until the next progress report
+	Complete time.Duration // estimated time to completion
+	FFTLen   int
+}
+
+// ReportProgress sends progress for the assignment aid (ap).
+func (c *Client) ReportProgress(ctx context.Context, aid string, p Progress) (Response, error) {
+	v := url.Values{}
+	v.Set("k", aid)
+	v.Set("stage", p.Stage)
+	v.Set("c", "0")
+	v.Set("p", strconv.FormatFloat(p.Percent, 'f', 4, 64))
+	v.Set("d", fmt.Sprint(int(p.Next.Seconds())))
+	v.Set("e", fmt.Sprint(int(p.Complete.Seconds())))
+	if p.FFTLen > 0 {
+		v.Set("fftlen", fmt.Sprint(p.FFTLen))
+	}
+	return c.do(ctx, "ap", v)
+}
+
+// SendResult reports a completed assignment (ar).  aid may be empty for
+// results without an assignment.
+func (c *Client) SendResult(ctx context.Context, aid string, r results.Result) error {
+	v := url.Values{}
+	v.Set("k", aid)
+	v.Set("m", r.Line)
+	v.Set("d", "1")
+	v.Set("n", fmt.Sprint(r.Exponent))
+	switch r.Type {
+	case results.TrialFactor:
+		v.Set("sf", fmt.Sprint(r.BitLo))
+		v.Set("ef", fmt.Sprint(r.BitHi))
+		if r.Found && r.Factor != "" {
+			v.Set("r", fmt.Sprint(ResultTFFactor))
+			v.Set("f", r.Factor)
+		} else {
+			v.Set("r", fmt.Sprint(ResultTFNoFactor))
+		}
+	case results.LucasLehmer:
+		if r.Prime {
+			v.Set("r", fmt.Sprint(ResultLLPrime))
+		} else {
+			v.Set("r", fmt.Sprint(ResultLL))
+			v.Set("rd", strings.TrimPrefix(r.Residue, "0x"))
+		}
+		v.Set("sc", fmt.Sprint(r.Shift))
+		v.Set("ec", "00000000")
+	}
+	_, err := c.do(ctx, "ar", v)
+	return err
+}
+
+// Unreserve returns the assignment aid to PrimeNet (au).
+func (c *Client) Unreserve(ctx context.Context, aid string) error {
+	v := url.Values{}
+	v.Set("k", aid)
+	_, err := c.do(ctx, "au", v)
+	return err
+}
+
+// do sends a transaction of type t and parses the reply.
+func (c *Client) do(ctx context.Context, t string, v url.Values) (Response, error) {
+	v.Set("v", ProtocolVersion)
+	v.Set("px", "GIMPS")
+	v.Set("t", t)
+	v.Set("g", c.GUID)
+	u := *c.BaseURL
+	u.RawQuery = v.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("primenet v5 %s: %w", t, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("primenet v5 %s: %s", t, resp.Status)
+	}
+	r, err := ParseResponse(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("primenet v5 %s: %w", t, err)
+	}
+	code, err := strconv.Atoi(r["pnErrorResult"])
+	if err != nil {
+		return r, fmt.Errorf("primenet v5 %s: missing pnErrorResult", t)
+	}
+	if code != ErrorOK {
+		return r, &Error{Code: code, Detail: r["pnErrorDetail"]}
+	}
+	return r, nil
+}
+
+// ParseResponse reads key=value lines up to the ==END== marker.
+func ParseResponse(rd io.Reader) (Response, error) {
+	r := make(Response)
+	sc := bufio.NewScanner(rd)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "==END==" {
+			return r, nil
+		}
+		if i := strings.IndexByte(line, '='); i > 0 {
+			r[line[:i]] = line[i+1:]
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return r, err
+	}
+	return r, fmt.Errorf("truncated response")
+}
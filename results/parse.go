@@ -0,0 +1,222 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package results
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrUnknownFormat is returned for lines that are not recognized as results.
+var ErrUnknownFormat = errors.New("results: unrecognized result line")
+
+// Type is the kind of work a result reports.
+type Type int
+
+// Result types.
+const (
+	TrialFactor Type = iota
+	LucasLehmer
+)
+
+func (t Type) String() string {
+	switch t {
+	case TrialFactor:
+		return "TF"
+	case LucasLehmer:
+		return "LL"
+	}
+	return fmt.Sprintf("Type(%d)", int(t))
+}
+
+// Result is a parsed results.txt line.
+type Result struct {
+	Line     string // the line as written by the worker
+	Type     Type
+	Program  string // mfakto, mfaktc, clLucas, CUDALucas...
+	Version  string
+	Kernel   string // TF kernel name, if given
+	User     string // from the "UID: user/computer," prefix, if present
+	Computer string
+	AID      string
+	Exponent uint64
+	Checksum string
+
+	// Trial factoring
+	BitLo, BitHi uint
+	Found        bool   // a factor was found
+	Factor       string // decimal value of the factor, when Found
+	Partial      bool   // the bit range was not completely searched
+
+	// Lucas-Lehmer
+	Prime   bool   // M(p) is prime
+	Residue string // 64-bit residue as printed, for composite results
+	Shift   uint64 // offset/shift count
+	FFT     string // FFT length as printed, e.g. 2048K
+	Errors  string // error counts as printed, e.g. 0/0
+}
+
+// Final reports whether the line completes its assignment: a finished trial
+// factoring range, a found factor, or a final LL residue.
+func (r *Result) Final() bool {
+	switch r.Type {
+	case TrialFactor:
+		return !r.Partial || r.Found
+	case LucasLehmer:
+		return r.Prime || r.Residue != ""
+	}
+	return false
+}
+
+// LineError reports a results.txt line that could not be parsed.
+type LineError struct {
+	Num  int // 1 based line number
+	Line string
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("results line %d: %v: %q", e.Num, e.Err, e.Line)
+}
+
+func (e *LineError) Unwrap() error { return e.Err }
+
+const (
+	uidPat  = `^(?:UID: ([^/,]+)/([^,]+), )?`
+	progPat = `\[(\S+) v?([^\s\]]+)(?: ([^\]]*))?\](?:\s+([0-9A-Fa-f]{8}))?\s*$`
+)
+
+var (
+	noFactorReg = regexp.MustCompile(uidPat + `no factor for M(\d+) from 2\^(\d+) to 2\^(\d+) ` + progPat)
+	foundReg    = regexp.MustCompile(uidPat + `found (\d+) factors? for M(\d+) from 2\^(\d+) to 2\^(\d+)( \(partially tested\))? ` + progPat)
+	factorReg   = regexp.MustCompile(uidPat + `M(\d+) has a factor: (\d+) \[TF:(\d+):(\d+)(\*?):([^\s\]]+) v?([^\s\]]+)(?: ([^\]]*))?\](?:\s+([0-9A-Fa-f]{8}))?\s*$`)
+	llReg       = regexp.MustCompile(uidPat + `M\( ?(\d+) ?\)([CP])(?:, (0x[0-9A-Fa-f_]+))?(.*)$`)
+	llProgReg   = regexp.MustCompile(`^(\S*[Ll]ucas\S*) v?(.+)$`)
+	llKeyReg    = regexp.MustCompile(`^(offset|shift|n|err|errors|AID|checksum|CRC32)\s*[=:]\s*(.+)$`)
+	llErrReg    = regexp.MustCompile(`^(\d+(?:/\d+)*) errors?$|^err(?:ors)?\s+(\S+)$`)
+)
+
+// Parse parses a single result line from mfakto, mfaktc, clLucas or CUDALucas.
+func Parse(line string) (Result, error) {
+	line = strings.TrimRight(line, "\r\n")
+	r := Result{Line: line}
+	trim := strings.TrimSpace(line)
+	var err error
+	switch {
+	case noFactorReg.MatchString(trim):
+		m := noFactorReg.FindStringSubmatch(trim)
+		r.User, r.Computer = m[1], m[2]
+		r.Exponent, err = strconv.ParseUint(m[3], 10, 64)
+		r.BitLo, r.BitHi = atou(m[4]), atou(m[5])
+		r.Program, r.Version, r.Kernel, r.Checksum = m[6], m[7], m[8], m[9]
+	case foundReg.MatchString(trim):
+		m := foundReg.FindStringSubmatch(trim)
+		r.User, r.Computer = m[1], m[2]
+		r.Found = true
+		r.Exponent, err = strconv.ParseUint(m[4], 10, 64)
+		r.BitLo, r.BitHi = atou(m[5]), atou(m[6])
+		r.Partial = m[7] != ""
+		r.Program, r.Version, r.Kernel, r.Checksum = m[8], m[9], m[10], m[11]
+	case factorReg.MatchString(trim):
+		m := factorReg.FindStringSubmatch(trim)
+		r.User, r.Computer = m[1], m[2]
+		r.Found = true
+		r.Exponent, err = strconv.ParseUint(m[3], 10, 64)
+		r.Factor = m[4]
+		r.BitLo, r.BitHi = atou(m[5]), atou(m[6])
+		r.Partial = m[7] == "*"
+		r.Program, r.Version, r.Kernel, r.Checksum = m[8], m[9], m[10], m[11]
+	case llReg.MatchString(trim):
+		m := llReg.FindStringSubmatch(trim)
+		r.Type = LucasLehmer
+		r.User, r.Computer = m[1], m[2]
+		r.Exponent, err = strconv.ParseUint(m[3], 10, 64)
+		r.Prime = m[4] == "P"
+		r.Residue = m[5]
+		if err == nil {
+			err = r.parseLL(m[6])
+		}
+		if err == nil && !r.Prime && r.Residue == "" {
+			err = errors.New("results: composite LL result without residue")
+		}
+	default:
+		return r, ErrUnknownFormat
+	}
+	return r, err
+}
+
+// parseLL fills in the comma separated fields following the LL residue.
+func (r *Result) parseLL(rest string) error {
+	for _, f := range strings.Split(rest, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if m := llKeyReg.FindStringSubmatch(f); m != nil {
+			switch m[1] {
+			case "offset", "shift":
+				s, err := strconv.ParseUint(m[2], 10, 64)
+				if err != nil {
+					return fmt.Errorf("results: bad shift %q", m[2])
+				}
+				r.Shift = s
+			case "n":
+				r.FFT = m[2]
+			case "err", "errors":
+				r.Errors = m[2]
+			case "AID":
+				r.AID = m[2]
+			case "checksum", "CRC32":
+				r.Checksum = m[2]
+			}
+			continue
+		}
+		if m := llErrReg.FindStringSubmatch(f); m != nil {
+			r.Errors = m[1] + m[2]
+			continue
+		}
+		if m := llProgReg.FindStringSubmatch(f); m != nil && r.Program == "" {
+			r.Program, r.Version = m[1], m[2]
+		}
+	}
+	return nil
+}
+
+func atou(s string) uint {
+	v, _ := strconv.ParseUint(s, 10, 0) // Regex ensures this can only be digits
+	return uint(v)
+}
+
+// ParseAll parses every non-blank line of a results file.  Lines that
+// cannot be parsed are returned as *LineError values rather than dropped.
+func ParseAll(data []byte) (res []Result, bad []*LineError) {
+	data = bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+	data = bytes.Replace(data, []byte("\r"), []byte("\n"), -1)
+	for i, l := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		r, err := Parse(l)
+		if err != nil {
+			bad = append(bad, &LineError{Num: i + 1, Line: l, Err: err})
+			continue
+		}
+		res = append(res, r)
+	}
+	return res, bad
+}
+
+// Lines returns the original text of each result.
+func Lines(res []Result) [][]byte {
+	lines := make([][]byte, len(res))
+	for i := range res {
+		lines[i] = []byte(res[i].Line)
+	}
+	return lines
+}
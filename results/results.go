@@ -0,0 +1,68 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+// Package results handles the result lines written to results.txt.
+package results
+
+import (
+	"bytes"
+	"errors"
+)
+
+// SendLimit is the largest batch accepted by mersenne.org/manual_result (2MB),
+// less a 1K buffer for safety.
+const SendLimit = 2*1024*1024 - 1024
+
+// ErrLineTooLong is returned when a single result line exceeds the batch limit.
+var ErrLineTooLong = errors.New("results: line exceeds batch limit")
+
+// Filter splits results into those ready to submit (send) and those to
+// leave in results.txt (keep).  A result is sent when it is Final and its
+// exponent is no longer being worked.
+func Filter(res []Result, working func(exponent uint64) bool) (keep, send []Result) {
+	keep = make([]Result, 0, len(res))
+	send = make([]Result, 0, len(res))
+	for i := range res {
+		if !res[i].Final() || working(res[i].Exponent) {
+			keep = append(keep, res[i])
+		} else {
+			send = append(send, res[i])
+		}
+	}
+	return keep, send
+}
+
+// Batches splits res into groups whose joined lines are no larger than
+// limit bytes.  Lines too long to send in any batch are left out of the
+// batches and returned in long, with ErrLineTooLong.
+func Batches(res []Result, limit int) (batches [][]Result, long []Result, err error) {
+	var batch []Result
+	size := 0
+	for i := range res {
+		n := len(res[i].Line) + 1 // newline separator
+		if n > limit {
+			// Protect against junk data in results file
+			long = append(long, res[i])
+			continue
+		}
+		if size+n > limit {
+			batches = append(batches, batch)
+			batch, size = nil, 0
+		}
+		batch = append(batch, res[i])
+		size += n
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	if len(long) > 0 {
+		err = ErrLineTooLong
+	}
+	return batches, long, err
+}
+
+// Join returns the lines of res separated by newlines.
+func Join(res []Result) []byte {
+	return bytes.Join(Lines(res), []byte("\n"))
+}
@@ -0,0 +1,155 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package results
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		line  string
+		check func(r Result) bool
+		final bool
+	}{
+		{
+			line: "no factor for M332197331 from 2^74 to 2^75 [mfakto 0.15pre6-Win cl_barrett15_82_gs_2]",
+			check: func(r Result) bool {
+				return r.Type == TrialFactor && r.Exponent == 332197331 && r.BitLo == 74 && r.BitHi == 75 &&
+					r.Program == "mfakto" && r.Version == "0.15pre6-Win" && r.Kernel == "cl_barrett15_82_gs_2" && !r.Found
+			},
+			final: true,
+		},
+		{
+			line: "UID: user/gpu0, no factor for M332197331 from 2^74 to 2^75 [mfaktc 0.21 barrett76_mul32_gs] 1A2B3C4D",
+			check: func(r Result) bool {
+				return r.User == "user" && r.Computer == "gpu0" && r.Program == "mfaktc" && r.Checksum == "1A2B3C4D"
+			},
+			final: true,
+		},
+		{
+			line: "M332197331 has a factor: 38814612911305349835664385407 [TF:74:75*:mfakto 0.15pre6-Win cl_barrett15_82_gs_2]",
+			check: func(r Result) bool {
+				return r.Found && r.Partial && r.Factor == "38814612911305349835664385407" && r.BitHi == 75
+			},
+			final: true,
+		},
+		{
+			line: "found 1 factor for M332197331 from 2^74 to 2^75 (partially tested) [mfakto 0.15pre6-Win cl_barrett15_82_gs_2]",
+			check: func(r Result) bool {
+				return r.Found && r.Partial
+			},
+			final: true,
+		},
+		{
+			line: "M( 45000017 )C, 0x1234abcd5678ef90, offset = 1234, n = 2560K, clLucas v1.04, AID: 0123456789ABCDEF0123456789ABCDEF",
+			check: func(r Result) bool {
+				return r.Type == LucasLehmer && r.Exponent == 45000017 && r.Residue == "0x1234abcd5678ef90" &&
+					r.Shift == 1234 && r.FFT == "2560K" && r.Program == "clLucas" && r.Version == "1.04" &&
+					r.AID == "0123456789ABCDEF0123456789ABCDEF"
+			},
+			final: true,
+		},
+		{
+			line: "M( 45000017 )P, n = 2560K, CUDALucas v2.06",
+			check: func(r Result) bool {
+				return r.Prime && r.Program == "CUDALucas"
+			},
+			final: true,
+		},
+	} {
+		r, err := Parse(test.line)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.line, err)
+			continue
+		}
+		if !test.check(r) {
+			t.Errorf("Parse(%q) = %+v", test.line, r)
+		}
+		if r.Final() != test.final {
+			t.Errorf("Parse(%q).Final() = %v, want %v", test.line, r.Final(), test.final)
+		}
+		if r.Line != test.line {
+			t.Errorf("Line = %q, want %q", r.Line, test.line)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	if _, err := Parse("Starting trial factoring M332197331 from 2^74 to 2^75"); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("progress line: err = %v, want ErrUnknownFormat", err)
+	}
+	if _, err := Parse("M( 45000017 )C, n = 2560K, clLucas v1.04"); err == nil {
+		t.Error("composite LL result without residue: no error")
+	}
+}
+
+func TestParseAll(t *testing.T) {
+	data := "no factor for M332197331 from 2^74 to 2^75 [mfakto 0.15 k]\r\n\r\njunk\r\n" +
+		"no factor for M332197333 from 2^74 to 2^75 [mfakto 0.15 k]"
+	res, bad := ParseAll([]byte(data))
+	if len(res) != 2 {
+		t.Errorf("%d results, want 2", len(res))
+	}
+	if len(bad) != 1 || bad[0].Num != 3 || bad[0].Line != "junk" || !errors.Is(bad[0], ErrUnknownFormat) {
+		t.Errorf("bad lines = %v", bad)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	res, _ := ParseAll([]byte("no factor for M1000003 from 2^74 to 2^75 [mfakto 0.15 k]\n" +
+		"no factor for M1000033 from 2^74 to 2^75 [mfakto 0.15 k]\n" +
+		"found 1 factor for M1000037 from 2^74 to 2^75 (partially tested) [mfakto 0.15 k]\n"))
+	keep, send := Filter(res, func(exp uint64) bool { return exp == 1000033 })
+	if len(keep) != 1 || keep[0].Exponent != 1000033 {
+		t.Errorf("keep = %v", keep)
+	}
+	if len(send) != 2 {
+		t.Errorf("send = %v", send)
+	}
+}
+
+func TestBatches(t *testing.T) {
+	var res []Result
+	for i := 0; i < 5; i++ {
+		res = append(res, Result{Line: strings.Repeat("x", 9)}) // 10 bytes with the newline
+	}
+	batches, long, err := Batches(res, 25)
+	if err != nil || len(long) != 0 {
+		t.Fatal(long, err)
+	}
+	if len(batches) != 3 || len(batches[0]) != 2 || len(batches[2]) != 1 {
+		t.Errorf("batch sizes = %d", len(batches))
+	}
+	if got := string(Join(batches[0])); got != "xxxxxxxxx\nxxxxxxxxx" {
+		t.Errorf("Join = %q", got)
+	}
+	if _, _, err := Batches(res, 5); !errors.Is(err, ErrLineTooLong) {
+		t.Errorf("long line: err = %v, want ErrLineTooLong", err)
+	}
+}
+
+func TestBatchesLongLine(t *testing.T) {
+	short := func(c string) Result { return Result{Line: strings.Repeat(c, 9)} }
+	junk := Result{Line: strings.Repeat("z", 40)}
+	res := []Result{short("a"), short("b"), junk, short("c"), short("d"), short("e")}
+	batches, long, err := Batches(res, 25)
+	if !errors.Is(err, ErrLineTooLong) {
+		t.Errorf("err = %v, want ErrLineTooLong", err)
+	}
+	if len(long) != 1 || long[0].Line != junk.Line {
+		t.Errorf("long = %v", long)
+	}
+	var got []string
+	for _, b := range batches {
+		got = append(got, string(Join(b)))
+	}
+	want := []string{"aaaaaaaaa\nbbbbbbbbb", "ccccccccc\nddddddddd", "eeeeeeeee"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("batches = %q, want %q", got, want)
+	}
+}
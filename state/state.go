@@ -0,0 +1,159 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+// Package state keeps per-device bookkeeping that does not belong in
+// worktodo.txt, such as the PrimeNet computer GUID and where each
+// assignment came from.
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Kunde21/MersenneManager/atomicfile"
+	"github.com/Kunde21/MersenneManager/filelock"
+)
+
+// FileName is the state file kept in each device's work directory.
+const FileName = "mmstate.json"
+
+// Assignment sources.
+const (
+	SourcePrimenet = "primenet"    // manual assignment page
+	SourceV5       = "primenet-v5" // v5 automated client API
+	SourceGPU72    = "gpu72"
+)
+
+// Assignment records an assignment fetched for a device.
+type Assignment struct {
+	Exponent uint64    `json:"exponent"`
+	AID      string    `json:"aid,omitempty"`
+	Kind     string    `json:"kind"` // worktodo work type
+	Source   string    `json:"source"`
+	Fetched  time.Time `json:"fetched"`
+	FFT      int       `json:"fft,omitempty"` // clLucas FFT length
+	Due      time.Time `json:"due,omitempty"`
+
+	// Progress of the assignment at the top of worktodo.txt
+	Started  time.Time `json:"started,omitempty"`
+	Percent  float64   `json:"percent,omitempty"`
+	Updated  time.Time `json:"updated,omitempty"`
+	Reported time.Time `json:"reported,omitempty"` // last v5 progress report
+}
+
+// State is the contents of a device's state file.
+type State struct {
+	GUID        string        `json:"guid,omitempty"`
+	Registered  bool          `json:"registered,omitempty"`
+	Assignments []*Assignment `json:"assignments,omitempty"`
+	AvgTime     time.Duration `json:"avg_time,omitempty"` // typical time to finish an assignment
+
+	path   string
+	locked bool // opened with Open, so it can be saved
+}
+
+// Load reads the state file in dir.  A missing file yields an empty State.
+// The State is a snapshot for reading; use Open to change it.
+func Load(dir string) (*State, error) {
+	return load(filepath.Join(dir, FileName))
+}
+
+// Open locks the state file in dir and reads it, for changes to be saved
+// with Save.  Close releases the lock.  The lock keeps the manager and
+// commands run alongside it, such as unreserve, from overwriting each
+// other's changes.
+func Open(ctx context.Context, dir string) (*State, error) {
+	path := filepath.Join(dir, FileName)
+	if err := filelock.LockContext(ctx, path); err != nil {
+		return nil, fmt.Errorf("locking %s: %w", FileName, err)
+	}
+	st, err := load(path)
+	if err != nil {
+		filelock.Unlock(path)
+		return nil, err
+	}
+	st.locked = true
+	return st, nil
+}
+
+func load(path string) (*State, error) {
+	st := &State{path: path}
+	data, err := ioutil.ReadFile(st.path)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Save writes the state back to its file, which is replaced whole so a
+// crash part way through leaves the previous version.
+func (st *State) Save() error {
+	if !st.locked {
+		return errors.New("state: saving a state file that wasn't opened with Open")
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(st.path, append(data, '\n'))
+}
+
+// Close releases the lock taken by Open.
+func (st *State) Close() {
+	if st.locked {
+		st.locked = false
+		filelock.Unlock(st.path)
+	}
+}
+
+// Find returns the newest assignment recorded for exponent, or nil.
+func (st *State) Find(exponent uint64) *Assignment {
+	for i := len(st.Assignments) - 1; i >= 0; i-- {
+		if st.Assignments[i].Exponent == exponent {
+			return st.Assignments[i]
+		}
+	}
+	return nil
+}
+
+// Add records a new assignment.
+func (st *State) Add(a *Assignment) {
+	st.Assignments = append(st.Assignments, a)
+}
+
+// Remove forgets the assignment a.
+func (st *State) Remove(a *Assignment) {
+	for i := range st.Assignments {
+		if st.Assignments[i] == a {
+			st.Assignments = append(st.Assignments[:i], st.Assignments[i+1:]...)
+			return
+		}
+	}
+}
+
+// Finished forgets the assignment a, completed at the given time, folding
+// its run time into AvgTime.
+func (st *State) Finished(a *Assignment, at time.Time) {
+	st.Remove(a)
+	if a.Started.IsZero() || !at.After(a.Started) {
+		return
+	}
+	d := at.Sub(a.Started)
+	if st.AvgTime > 0 {
+		d = (3*st.AvgTime + d) / 4
+	}
+	st.AvgTime = d
+}
@@ -0,0 +1,63 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package state
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Kunde21/MersenneManager/filelock"
+)
+
+func TestOpenSave(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	if st, err := Load(dir); err != nil || st.Save() == nil {
+		t.Errorf("Load: saved without the lock (err %v)", err)
+	}
+
+	st, err := Open(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.GUID = "0123456789abcdef0123456789abcdef"
+	st.Add(&Assignment{Exponent: 332197331, Source: SourcePrimenet})
+	if err := st.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Held until Close
+	defer func(n int, d time.Duration) { filelock.Retries, filelock.RetryDelay = n, d }(filelock.Retries, filelock.RetryDelay)
+	filelock.Retries, filelock.RetryDelay = 1, time.Millisecond
+	if _, err := Open(ctx, dir); !errors.Is(err, filelock.ErrLocked) {
+		t.Errorf("second Open: err = %v, want ErrLocked", err)
+	}
+	st.Close()
+	st2, err := Open(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st2.Close()
+	if st2.GUID != st.GUID || st2.Find(332197331) == nil {
+		t.Errorf("reloaded state = %+v", st2)
+	}
+
+	// The version replaced is kept, and no temporary file is left
+	st2.GUID = ""
+	if err := st2.Save(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, FileName)
+	if _, err := os.Stat(path + ".bak"); err != nil {
+		t.Error(err)
+	}
+	if tmp, _ := filepath.Glob(path + ".tmp*"); len(tmp) > 0 {
+		t.Errorf("temporary files left: %v", tmp)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+// Package worker runs mfakto and clLucas processes, restarting them when
+// they exit and stopping them when the manager shuts down.
+package worker
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// Defaults for the Worker timing fields.
+const (
+	DefaultMinBackoff  = 5 * time.Second
+	DefaultMaxBackoff  = 10 * time.Minute
+	DefaultStopTimeout = 30 * time.Second
+	DefaultHealthy     = 10 * time.Minute
+)
+
+// Worker describes a supervised process.
+type Worker struct {
+	Name string   // prefix for logged output
+	Path string   // executable
+	Args []string // command line arguments
+	Dir  string   // working directory
+	Log  *log.Logger
+
+	// ArgsFunc, if set, is called before each start to supply the
+	// command line arguments in place of Args.
+	ArgsFunc func() []string
+	// Output, if set, is called with each line the process writes.
+	// It is called from two goroutines, for stdout and stderr.
+	Output func(line string)
+
+	MinBackoff  time.Duration // delay before the first restart
+	MaxBackoff  time.Duration // longest delay between restarts
+	StopTimeout time.Duration // time allowed to exit after an interrupt
+	Healthy     time.Duration // run time after which the backoff is reset
+}
+
+func (w *Worker) defaults() {
+	if w.Log == nil {
+		w.Log = log.New(os.Stderr, log.Prefix(), log.Flags())
+	}
+	if w.MinBackoff <= 0 {
+		w.MinBackoff = DefaultMinBackoff
+	}
+	if w.MaxBackoff < w.MinBackoff {
+		w.MaxBackoff = DefaultMaxBackoff
+	}
+	if w.StopTimeout <= 0 {
+		w.StopTimeout = DefaultStopTimeout
+	}
+	if w.Healthy <= 0 {
+		w.Healthy = DefaultHealthy
+	}
+}
+
+// Run starts the process and restarts it, with exponential backoff,
+// whenever it exits.  When ctx is cancelled the process is interrupted,
+// and killed if it has not exited within StopTimeout.  Run returns
+// ctx.Err() once the process has stopped, or an error if the process
+// cannot be started at all.
+func (w *Worker) Run(ctx context.Context) error {
+	w.defaults()
+	backoff := w.MinBackoff
+	for {
+		start := time.Now()
+		err := w.runOnce(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			return err
+		}
+		if time.Since(start) >= w.Healthy {
+			backoff = w.MinBackoff
+		}
+		w.Log.Printf("%s exited (%v), restarting in %v", w.Name, err, backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > w.MaxBackoff {
+			backoff = w.MaxBackoff
+		}
+	}
+}
+
+func (w *Worker) runOnce(ctx context.Context) error {
+	args := w.Args
+	if w.ArgsFunc != nil {
+		args = w.ArgsFunc()
+	}
+	cmd := exec.Command(w.Path, args...)
+	cmd.Dir = w.Dir
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	w.Log.Printf("%s started: pid %d", w.Name, cmd.Process.Pid)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go w.copyLines(&wg, stdout)
+	go w.copyLines(&wg, stderr)
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			w.stop(cmd, done)
+		case <-done:
+		}
+	}()
+	wg.Wait() // output must be drained before Wait closes the pipes
+	err = cmd.Wait()
+	close(done)
+	return err
+}
+
+// stop interrupts the process so it can write a checkpoint, then kills
+// it if it is still running after StopTimeout.
+func (w *Worker) stop(cmd *exec.Cmd, done <-chan struct{}) {
+	w.Log.Printf("%s stopping", w.Name)
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		// Interrupt is not available on Windows
+		cmd.Process.Kill()
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(w.StopTimeout):
+		w.Log.Printf("%s did not stop, killing", w.Name)
+		cmd.Process.Kill()
+	}
+}
+
+func (w *Worker) copyLines(wg *sync.WaitGroup, r io.Reader) {
+	defer wg.Done()
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		w.Log.Printf("[%s] %s", w.Name, sc.Text())
+		if w.Output != nil {
+			w.Output(sc.Text())
+		}
+	}
+}
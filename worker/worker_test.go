@@ -0,0 +1,199 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder collects logged and output lines, and signals each one that
+// contains a watched string.
+type recorder struct {
+	mu    sync.Mutex
+	lines []string
+	watch string
+	seen  chan string
+}
+
+func newRecorder(watch string) *recorder {
+	return &recorder{watch: watch, seen: make(chan string, 100)}
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.add(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+func (r *recorder) add(line string) {
+	r.mu.Lock()
+	r.lines = append(r.lines, line)
+	r.mu.Unlock()
+	if strings.Contains(line, r.watch) {
+		select {
+		case r.seen <- line:
+		default: // not waited for
+		}
+	}
+}
+
+// wait returns the next n watched lines.
+func (r *recorder) wait(t *testing.T, n int) []string {
+	t.Helper()
+	var lines []string
+	for len(lines) < n {
+		select {
+		case l := <-r.seen:
+			lines = append(lines, l)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out after %q", lines)
+		}
+	}
+	return lines
+}
+
+func (r *recorder) contains(s string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, l := range r.lines {
+		if strings.Contains(l, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// script returns a Worker running the shell script body in a temporary
+// directory, logging to rec.
+func script(t *testing.T, body string, rec *recorder) *Worker {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "worker.sh")
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return &Worker{
+		Name:       "test",
+		Path:       path,
+		Dir:        dir,
+		Log:        log.New(rec, "", 0),
+		Output:     rec.add,
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 80 * time.Millisecond,
+		Healthy:    time.Hour,
+	}
+}
+
+// run runs w until cancel is called, and returns a channel for Run's
+// result.
+func run(w *Worker) (cancel func(), result <-chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	res := make(chan error, 1)
+	go func() { res <- w.Run(ctx) }()
+	return cancel, res
+}
+
+func backoffs(lines []string) []string {
+	var d []string
+	for _, l := range lines {
+		d = append(d, l[strings.LastIndex(l, " ")+1:])
+	}
+	return d
+}
+
+func TestRestartBackoff(t *testing.T) {
+	rec := newRecorder("restarting in")
+	w := script(t, "echo crashing\nexit 3\n", rec)
+	cancel, res := run(w)
+	got := backoffs(rec.wait(t, 6))
+	cancel()
+	if err := <-res; !errors.Is(err, context.Canceled) {
+		t.Errorf("Run = %v, want context.Canceled", err)
+	}
+	want := []string{"10ms", "20ms", "40ms", "80ms", "80ms", "80ms"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("backoffs = %v, want %v", got, want)
+	}
+	if !rec.contains("exited (exit status 3)") || !rec.contains("[test] crashing") {
+		t.Error("crash or output not logged")
+	}
+}
+
+func TestHealthyReset(t *testing.T) {
+	rec := newRecorder("restarting in")
+	// The fourth run lasts past Healthy before crashing
+	w := script(t, `n=$(cat count 2>/dev/null || echo 0)
+n=$((n+1))
+echo $n > count
+if [ $n -eq 4 ]; then sleep 0.5; fi
+exit 1
+`, rec)
+	w.Healthy = 250 * time.Millisecond
+	cancel, res := run(w)
+	got := backoffs(rec.wait(t, 5))
+	cancel()
+	<-res
+	want := []string{"10ms", "20ms", "40ms", "10ms", "20ms"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("backoffs = %v, want %v", got, want)
+	}
+}
+
+func TestStop(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		trap   string
+		killed bool
+	}{
+		{name: "interrupt", trap: `trap 'echo interrupted; exit 0' INT`},
+		{name: "kill", trap: `trap '' INT`, killed: true},
+	} {
+		rec := newRecorder("ready")
+		w := script(t, test.trap+"\necho ready\nwhile :; do sleep 0.05; done\n", rec)
+		w.StopTimeout = 200 * time.Millisecond
+		cancel, res := run(w)
+		rec.wait(t, 1)
+		start := time.Now()
+		cancel()
+		select {
+		case err := <-res:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("%s: Run = %v, want context.Canceled", test.name, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: worker not stopped", test.name)
+		}
+		if killed := rec.contains("did not stop, killing"); killed != test.killed {
+			t.Errorf("%s: killed = %v, want %v", test.name, killed, test.killed)
+		}
+		if !test.killed && !rec.contains("[test] interrupted") {
+			t.Errorf("%s: worker not interrupted", test.name)
+		}
+		if test.killed && time.Since(start) < w.StopTimeout {
+			t.Errorf("%s: killed after %v, before StopTimeout", test.name, time.Since(start))
+		}
+		if rec.contains("restarting") {
+			t.Errorf("%s: restarted after cancel", test.name)
+		}
+	}
+}
+
+func TestStartError(t *testing.T) {
+	w := &Worker{Name: "missing", Path: filepath.Join(t.TempDir(), "no-such-program"), Log: log.New(ioutil.Discard, "", 0)}
+	if err := w.Run(context.Background()); err == nil {
+		t.Error("no error for a missing executable")
+	}
+}
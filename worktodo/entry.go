@@ -0,0 +1,181 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package worktodo
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Work types found in worktodo.txt.
+const (
+	Factor      = "Factor"
+	Test        = "Test"
+	DoubleCheck = "DoubleCheck"
+	PRP         = "PRP"
+	Pfactor     = "Pfactor"
+	Pminus1     = "Pminus1"
+	ECM2        = "ECM2"
+)
+
+// layout gives the parameter index of each typed field for a work type,
+// counted after the assignment ID.  -1 marks a field the type doesn't have.
+type layout struct {
+	exp, lo, hi, pm1, saved int
+	kbnc                    bool // exponent is given as k,b,n,c
+}
+
+var layouts = map[string]layout{
+	Factor:      {exp: 0, lo: 1, hi: 2, pm1: -1, saved: -1},
+	Test:        {exp: 0, lo: 1, hi: -1, pm1: 2, saved: -1},
+	DoubleCheck: {exp: 0, lo: 1, hi: -1, pm1: 2, saved: -1},
+	PRP:         {exp: 2, lo: 4, hi: -1, pm1: -1, saved: 5, kbnc: true},
+	Pfactor:     {exp: 2, lo: 4, hi: -1, pm1: -1, saved: 5, kbnc: true},
+	Pminus1:     {exp: 2, lo: -1, hi: -1, pm1: -1, saved: -1, kbnc: true},
+	ECM2:        {exp: 2, lo: -1, hi: -1, pm1: -1, saved: -1, kbnc: true},
+}
+
+var (
+	aidReg  = regexp.MustCompile(`^([0-9A-Fa-f]{32}|[Nn]/[Aa])$`)
+	kindReg = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*$`)
+)
+
+// Entry is a single assignment line of worktodo.txt.
+//
+// Exponent, BitLo, BitHi, PM1Done and TestsSaved are filled in for the work
+// types listed above; Params holds every field after the assignment ID as
+// written, so unknown work types are kept intact.
+type Entry struct {
+	Kind       string  // work type before the '='
+	AID        string  // assignment ID, empty if the line has none
+	Exponent   uint64  // Mersenne exponent, 0 for non-Mersenne k*b^n+c work
+	BitLo      uint    // bits already trial factored
+	BitHi      uint    // Factor: bit level to factor to
+	PM1Done    bool    // Test/DoubleCheck: P-1 factoring already done
+	TestsSaved float64 // PRP/Pfactor: primality tests saved by finding a factor
+	Params     []string
+}
+
+// ParseEntry parses an assignment line.  ok is false for lines that are not
+// assignments, such as comments, blanks and [Worker #n] headers.
+func ParseEntry(line string) (e Entry, ok bool) {
+	line = strings.TrimSpace(line)
+	idx := strings.IndexByte(line, '=')
+	if idx <= 0 || !kindReg.MatchString(line[:idx]) {
+		return e, false
+	}
+	e.Kind = line[:idx]
+	e.Params = strings.Split(line[idx+1:], ",")
+	for i := range e.Params {
+		e.Params[i] = strings.TrimSpace(e.Params[i])
+	}
+	if len(e.Params) > 1 && aidReg.MatchString(e.Params[0]) {
+		e.AID, e.Params = e.Params[0], e.Params[1:]
+	}
+
+	lay, known := lookup(e.Kind)
+	if !known {
+		return e, true
+	}
+	if lay.kbnc {
+		if len(e.Params) > 3 && e.Params[0] == "1" && e.Params[1] == "2" && e.Params[3] == "-1" {
+			e.Exponent, _ = strconv.ParseUint(e.Params[2], 10, 64)
+		}
+	} else if lay.exp < len(e.Params) {
+		e.Exponent, _ = strconv.ParseUint(e.Params[lay.exp], 10, 64)
+	}
+	e.BitLo = uint(e.uintParam(lay.lo))
+	e.BitHi = uint(e.uintParam(lay.hi))
+	e.PM1Done = e.uintParam(lay.pm1) != 0
+	if lay.saved >= 0 && lay.saved < len(e.Params) {
+		e.TestsSaved, _ = strconv.ParseFloat(e.Params[lay.saved], 64)
+	}
+	return e, true
+}
+
+func (e *Entry) uintParam(i int) uint64 {
+	if i < 0 || i >= len(e.Params) {
+		return 0
+	}
+	v, _ := strconv.ParseUint(e.Params[i], 10, 64)
+	return v
+}
+
+func lookup(kind string) (layout, bool) {
+	for k, lay := range layouts {
+		if strings.EqualFold(k, kind) {
+			return lay, true
+		}
+	}
+	return layout{exp: -1, lo: -1, hi: -1, pm1: -1, saved: -1}, false
+}
+
+// Is reports whether e is one of the given work types.
+func (e *Entry) Is(kinds ...string) bool {
+	for _, k := range kinds {
+		if strings.EqualFold(e.Kind, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// String formats e as a worktodo.txt line, without a line ending.
+func (e Entry) String() string {
+	params := e.fields()
+	if e.AID != "" {
+		params = append([]string{e.AID}, params...)
+	}
+	return e.Kind + "=" + strings.Join(params, ",")
+}
+
+// fields returns Params updated with the typed field values.
+func (e *Entry) fields() []string {
+	lay, known := lookup(e.Kind)
+	params := append([]string(nil), e.Params...)
+	if !known {
+		return params
+	}
+	set := func(i int, v string) {
+		if i < 0 {
+			return
+		}
+		for len(params) <= i {
+			params = append(params, "0")
+		}
+		params[i] = v
+	}
+	if lay.kbnc {
+		if e.Exponent != 0 {
+			set(0, "1")
+			set(1, "2")
+			set(3, "-1")
+			set(lay.exp, strconv.FormatUint(e.Exponent, 10))
+		}
+	} else {
+		set(lay.exp, strconv.FormatUint(e.Exponent, 10))
+	}
+	set(lay.lo, fmt.Sprint(e.BitLo))
+	set(lay.hi, fmt.Sprint(e.BitHi))
+	if lay.pm1 >= 0 {
+		pm1 := "0"
+		if e.PM1Done {
+			pm1 = "1"
+		}
+		set(lay.pm1, pm1)
+	}
+	if lay.saved >= 0 && (e.TestsSaved != 0 || lay.saved < len(params)) {
+		set(lay.saved, strconv.FormatFloat(e.TestsSaved, 'f', -1, 64))
+	}
+	return params
+}
+
+func (e *Entry) clone() Entry {
+	c := *e
+	c.Params = append([]string(nil), e.Params...)
+	return c
+}
@@ -0,0 +1,229 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+// Package worktodo reads and edits worktodo.txt files.
+//
+// A File keeps every line as it was read, so comments, blank lines,
+// [Worker #n] headers and unknown work types survive an edit, and an
+// unmodified file is written back byte for byte.
+package worktodo
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// File is a parsed worktodo.txt.
+type File struct {
+	lines []line
+	eol   string // line ending used for inserted lines
+}
+
+type line struct {
+	raw   string
+	eol   string
+	entry *Entry // nil for non-assignment lines
+	orig  Entry  // entry as parsed, to detect edits
+}
+
+// Parse splits data into lines and parses the assignments.
+// Line endings may be \n, \r\n or \r.
+func Parse(data []byte) *File {
+	f := &File{eol: "\n"}
+	crlf := 0
+	for len(data) > 0 {
+		i := bytes.IndexAny(data, "\r\n")
+		l := line{}
+		if i < 0 {
+			l.raw, data = string(data), nil
+		} else {
+			l.raw = string(data[:i])
+			n := 1
+			if data[i] == '\r' && i+1 < len(data) && data[i+1] == '\n' {
+				n = 2
+				crlf++
+			}
+			l.eol, data = string(data[i:i+n]), data[i+n:]
+		}
+		if e, ok := ParseEntry(l.raw); ok {
+			l.entry, l.orig = &e, e.clone()
+		}
+		f.lines = append(f.lines, l)
+	}
+	if crlf > 0 && crlf*2 >= len(f.lines) {
+		f.eol = "\r\n"
+	}
+	return f
+}
+
+// Bytes returns the file contents.  Lines whose entry has not been
+// modified are returned exactly as they were parsed.
+func (f *File) Bytes() []byte {
+	var buf bytes.Buffer
+	for _, l := range f.lines {
+		if l.entry != nil && l.entry.String() != l.orig.String() {
+			buf.WriteString(l.entry.String())
+		} else {
+			buf.WriteString(l.raw)
+		}
+		buf.WriteString(l.eol)
+	}
+	return buf.Bytes()
+}
+
+// Entries returns the assignments of the given work types, or all
+// assignments when no type is given.  Entries may be modified in place.
+func (f *File) Entries(kinds ...string) []*Entry {
+	var ents []*Entry
+	for _, l := range f.lines {
+		if l.entry != nil && (len(kinds) == 0 || l.entry.Is(kinds...)) {
+			ents = append(ents, l.entry)
+		}
+	}
+	return ents
+}
+
+// Find returns the first assignment for exponent, or nil.
+func (f *File) Find(exponent uint64) *Entry {
+	for _, l := range f.lines {
+		if l.entry != nil && l.entry.Exponent == exponent {
+			return l.entry
+		}
+	}
+	return nil
+}
+
+// Insert adds entries after the last assignment in the file, or at the
+// end of the file when it has none.
+func (f *File) Insert(entries ...Entry) {
+	at := len(f.lines)
+	for i := len(f.lines) - 1; i >= 0; i-- {
+		if f.lines[i].entry != nil {
+			at = i + 1
+			break
+		}
+	}
+	if at > 0 && f.lines[at-1].eol == "" {
+		f.lines[at-1].eol = f.eol
+	}
+	add := make([]line, len(entries))
+	for i := range entries {
+		e := entries[i].clone()
+		add[i] = line{raw: e.String(), eol: f.eol, entry: &e, orig: e.clone()}
+	}
+	f.lines = append(f.lines[:at], append(add, f.lines[at:]...)...)
+}
+
+// Remove deletes the lines holding the given entries, as returned by
+// Entries or Find.  It reports the number of lines removed.
+func (f *File) Remove(entries ...*Entry) int {
+	n := 0
+	kept := f.lines[:0]
+	for _, l := range f.lines {
+		if l.entry != nil && contains(entries, l.entry) {
+			n++
+			continue
+		}
+		kept = append(kept, l)
+	}
+	f.lines = kept
+	return n
+}
+
+func contains(entries []*Entry, e *Entry) bool {
+	for _, c := range entries {
+		if c == e {
+			return true
+		}
+	}
+	return false
+}
+
+var scanReg = regexp.MustCompile(`\b(Factor|DoubleCheck|Test|PRP|Pfactor|Pminus1|ECM2)=[0-9A-Za-z/,.\-]+`)
+
+// Scan finds the assignment lines of the given work types in a web page
+// or other text.  Repeated assignments are returned once.
+func Scan(data []byte, kinds ...string) []Entry {
+	var ents []Entry
+	seen := make(map[string]bool)
+	for _, m := range scanReg.FindAll(data, -1) {
+		e, ok := ParseEntry(string(m))
+		if !ok || (len(kinds) > 0 && !e.Is(kinds...)) || seen[e.String()] {
+			continue
+		}
+		seen[e.String()] = true
+		ents = append(ents, e)
+	}
+	return ents
+}
+
+var (
+	rowReg   = regexp.MustCompile(`(?is)<tr[^>]*>(.*?)</tr>`)
+	cellReg  = regexp.MustCompile(`(?is)<t[dh][^>]*>(.*?)</t[dh]>`)
+	tagReg   = regexp.MustCompile(`<[^>]*>`)
+	cellExp  = regexp.MustCompile(`^M?([0-9]{6,10})$`)
+	cellAID  = regexp.MustCompile(`^[0-9A-Fa-f]{32}$`)
+	rowKinds = []struct {
+		re   *regexp.Regexp
+		kind string
+	}{
+		{regexp.MustCompile(`(?i)factor|\b(ll|dc)?tf\b`), Factor},
+		{regexp.MustCompile(`(?i)\bprp\b`), PRP},
+		{regexp.MustCompile(`(?i)\bp-1\b|pminus1|pfactor`), Pfactor},
+		{regexp.MustCompile(`(?i)double|\bdc\b`), DoubleCheck},
+		{regexp.MustCompile(`(?i)\bll\b|lucas|first.time|\btest\b`), Test},
+	}
+)
+
+// ScanRows finds assignments listed as rows of an HTML table, as on the
+// account assignment pages.  A row is an assignment when one of its cells
+// holds just an exponent.  A cell holding a 32 digit hex number is taken as
+// the assignment ID, and the work type is guessed from the row's text.
+// Bit levels are not filled in.
+func ScanRows(data []byte, kinds ...string) []Entry {
+	var ents []Entry
+	seen := make(map[uint64]bool)
+	for _, row := range rowReg.FindAllSubmatch(data, -1) {
+		var e Entry
+		var text []string
+		for _, cell := range cellReg.FindAllSubmatch(row[1], -1) {
+			c := strings.TrimSpace(html.UnescapeString(string(tagReg.ReplaceAll(cell[1], nil))))
+			text = append(text, c)
+			if m := cellExp.FindStringSubmatch(c); m != nil && e.Exponent == 0 {
+				e.Exponent, _ = strconv.ParseUint(m[1], 10, 64)
+			} else if cellAID.MatchString(c) {
+				e.AID = c
+			}
+		}
+		if e.Exponent == 0 || seen[e.Exponent] {
+			continue
+		}
+		line := strings.Join(text, " ")
+		for _, rk := range rowKinds {
+			if rk.re.MatchString(line) {
+				e.Kind = rk.kind
+				break
+			}
+		}
+		if len(kinds) > 0 && !e.Is(kinds...) {
+			continue
+		}
+		seen[e.Exponent] = true
+		ents = append(ents, e)
+	}
+	return ents
+}
+
+// SetTargets raises the "factor to" bit level of each Factor entry to at least target.
+func SetTargets(target uint, work []Entry) []Entry {
+	for i := range work {
+		if work[i].Is(Factor) && work[i].BitHi < target {
+			work[i].BitHi = target
+		}
+	}
+	return work
+}
@@ -0,0 +1,176 @@
+// Copyright ©2016 Chad Kunde. All rights reserved.
+// Use and distribution of this source code is governed
+// by an MIT-style license that can be found in the LICENSE file.
+
+package worktodo
+
+import (
+	"reflect"
+	"testing"
+)
+
+const aid = "0123456789ABCDEF0123456789ABCDEF"
+
+func TestParseEntry(t *testing.T) {
+	for _, test := range []struct {
+		line string
+		ok   bool
+		want Entry
+	}{
+		{line: "", ok: false},
+		{line: "# comment", ok: false},
+		{line: "[Worker #1]", ok: false},
+		{
+			line: "Factor=" + aid + ",332197331,74,75",
+			ok:   true,
+			want: Entry{Kind: Factor, AID: aid, Exponent: 332197331, BitLo: 74, BitHi: 75,
+				Params: []string{"332197331", "74", "75"}},
+		},
+		{
+			line: "Factor=N/A,332197331,74,75",
+			ok:   true,
+			want: Entry{Kind: Factor, AID: "N/A", Exponent: 332197331, BitLo: 74, BitHi: 75,
+				Params: []string{"332197331", "74", "75"}},
+		},
+		{
+			line: "DoubleCheck=" + aid + ",45000017,72,1",
+			ok:   true,
+			want: Entry{Kind: DoubleCheck, AID: aid, Exponent: 45000017, BitLo: 72, PM1Done: true,
+				Params: []string{"45000017", "72", "1"}},
+		},
+		{
+			line: "Test=80000023,74,0",
+			ok:   true,
+			want: Entry{Kind: Test, Exponent: 80000023, BitLo: 74,
+				Params: []string{"80000023", "74", "0"}},
+		},
+		{
+			line: "PRP=" + aid + ",1,2,100000007,-1,76,2",
+			ok:   true,
+			want: Entry{Kind: PRP, AID: aid, Exponent: 100000007, BitLo: 76, TestsSaved: 2,
+				Params: []string{"1", "2", "100000007", "-1", "76", "2"}},
+		},
+		{
+			line: "Cert=" + aid + ",1,2,100000007,-1,5",
+			ok:   true,
+			want: Entry{Kind: "Cert", AID: aid,
+				Params: []string{"1", "2", "100000007", "-1", "5"}},
+		},
+	} {
+		got, ok := ParseEntry(test.line)
+		if ok != test.ok {
+			t.Errorf("ParseEntry(%q) ok = %v, want %v", test.line, ok, test.ok)
+			continue
+		}
+		if ok && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseEntry(%q) = %+v, want %+v", test.line, got, test.want)
+		}
+	}
+}
+
+func TestEntryString(t *testing.T) {
+	for _, line := range []string{
+		"Factor=" + aid + ",332197331,74,75",
+		"DoubleCheck=45000017,72,1",
+		"PRP=" + aid + ",1,2,100000007,-1,76,2",
+		"Cert=" + aid + ",1,2,100000007,-1,5",
+	} {
+		e, _ := ParseEntry(line)
+		if got := e.String(); got != line {
+			t.Errorf("String() = %q, want %q", got, line)
+		}
+	}
+
+	e, _ := ParseEntry("Factor=" + aid + ",332197331,74,75")
+	e.BitHi = 77
+	if got, want := e.String(), "Factor="+aid+",332197331,74,77"; got != want {
+		t.Errorf("edited String() = %q, want %q", got, want)
+	}
+}
+
+func TestFileRoundTrip(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"Factor=" + aid + ",332197331,74,75\n",
+		"[Worker #1]\r\n# keep me\r\nFactor=332197331,74,75\r\n\r\nUnknown=x,y\r\n",
+		"Test=80000023,74,0\nTest=80000027,74,0",
+	} {
+		if got := string(Parse([]byte(data)).Bytes()); got != data {
+			t.Errorf("round trip of %q = %q", data, got)
+		}
+	}
+}
+
+func TestFileEdit(t *testing.T) {
+	f := Parse([]byte("[Worker #1]\r\nFactor=332197331,74,75\r\n# trailer\r\n"))
+	f.Find(332197331).BitHi = 76
+	f.Insert(Entry{Kind: Factor, AID: aid, Exponent: 332197333, BitLo: 74, BitHi: 75})
+	want := "[Worker #1]\r\nFactor=332197331,74,76\r\nFactor=" + aid + ",332197333,74,75\r\n# trailer\r\n"
+	if got := string(f.Bytes()); got != want {
+		t.Errorf("after edit and insert got %q, want %q", got, want)
+	}
+
+	if n := f.Remove(f.Find(332197331)); n != 1 {
+		t.Errorf("Remove = %d, want 1", n)
+	}
+	if f.Find(332197331) != nil {
+		t.Error("removed entry still found")
+	}
+	if n := len(f.Entries(Factor)); n != 1 {
+		t.Errorf("%d Factor entries left, want 1", n)
+	}
+	if n := len(f.Entries(Test)); n != 0 {
+		t.Errorf("%d Test entries, want 0", n)
+	}
+
+	f = Parse([]byte("Factor=332197331,74,75"))
+	f.Insert(Entry{Kind: Factor, Exponent: 332197333, BitLo: 74, BitHi: 75})
+	if got, want := string(f.Bytes()), "Factor=332197331,74,75\nFactor=332197333,74,75\n"; got != want {
+		t.Errorf("insert after unterminated line got %q, want %q", got, want)
+	}
+}
+
+func TestScan(t *testing.T) {
+	page := []byte("<pre>\nFactor=" + aid + ",332197331,74,75\n" +
+		"Factor=" + aid + ",332197331,74,75\n" +
+		"DoubleCheck=45000017,72,1</pre>")
+	got := Scan(page)
+	if len(got) != 2 {
+		t.Fatalf("Scan found %d entries, want 2: %v", len(got), got)
+	}
+	if got := Scan(page, DoubleCheck); len(got) != 1 || got[0].Exponent != 45000017 {
+		t.Errorf("Scan(DoubleCheck) = %v", got)
+	}
+}
+
+func TestScanRows(t *testing.T) {
+	page := []byte(`<table>
+<tr><th>Exponent</th><th>Work</th><th>AID</th></tr>
+<tr><td>M332197331</td><td>Trial factor</td><td>` + aid + `</td></tr>
+<tr><td><a href="#">45000017</a></td><td>Double check</td><td></td></tr>
+<tr><td>332197331</td><td>Trial factor</td><td></td></tr>
+</table>`)
+	got := ScanRows(page)
+	want := []Entry{
+		{Kind: Factor, Exponent: 332197331, AID: aid},
+		{Kind: DoubleCheck, Exponent: 45000017},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ScanRows = %+v, want %+v", got, want)
+	}
+	if got := ScanRows(page, DoubleCheck); len(got) != 1 {
+		t.Errorf("ScanRows(DoubleCheck) = %+v", got)
+	}
+}
+
+func TestSetTargets(t *testing.T) {
+	work := []Entry{
+		{Kind: Factor, Exponent: 1, BitHi: 72},
+		{Kind: Factor, Exponent: 2, BitHi: 76},
+		{Kind: Test, Exponent: 3},
+	}
+	SetTargets(74, work)
+	if work[0].BitHi != 74 || work[1].BitHi != 76 || work[2].BitHi != 0 {
+		t.Errorf("SetTargets(74) = %+v", work)
+	}
+}